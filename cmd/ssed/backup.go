@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type backupControl int
+
+const (
+	backupNone backupControl = iota
+	backupSimple
+	backupNumbered
+	backupExisting
+)
+
+// backupOptions says how -i backs up the files it changes, following GNU
+// cp and mv: simple backups are named file+suffix, numbered ones file.~N~,
+// and existing makes numbered backups only of files that already have them.
+// With dir set, backups go to a tree under dir that mirrors the files'
+// paths instead of next to the files.
+type backupOptions struct {
+	control backupControl
+	suffix  string
+	dir     string
+}
+
+// parseBackup interprets --backup, --suffix and --backup-dir. A --backup
+// value that isn't a control name is a suffix for simple backups, as in
+// "--backup .bak".
+func parseBackup(value, suffix, dir string) backupOptions {
+	b := backupOptions{suffix: suffix, dir: dir}
+
+	switch value {
+	case "", "none", "off":
+		if dir != "" {
+			// Mirrored backups keep the file's name unless asked otherwise.
+			b.control, b.suffix = backupSimple, ""
+		}
+	case "simple", "never":
+		b.control = backupSimple
+	case "numbered", "t":
+		b.control = backupNumbered
+	case "existing", "nil":
+		b.control = backupExisting
+	default:
+		b.control, b.suffix = backupSimple, value
+	}
+
+	return b
+}
+
+// path returns the name of the backup of filename.
+func (b backupOptions) path(filename string) (string, error) {
+	name := filename
+
+	if b.dir != "" {
+		abs, err := filepath.Abs(filename)
+		if err != nil {
+			return "", err
+		}
+
+		cwd, err := os.Getwd()
+		if err != nil {
+			return "", err
+		}
+
+		// Files below the current directory keep their relative path;
+		// others are mirrored by their absolute one.
+		rel, err := filepath.Rel(cwd, abs)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			rel = strings.TrimPrefix(abs, filepath.VolumeName(abs))
+		}
+
+		name = filepath.Join(b.dir, rel)
+	}
+
+	switch b.control {
+	case backupNumbered:
+		n, err := lastBackupNumber(name)
+		if err != nil {
+			return "", err
+		}
+
+		return fmt.Sprintf("%s.~%d~", name, n+1), nil
+	case backupExisting:
+		n, err := lastBackupNumber(name)
+		if err != nil {
+			return "", err
+		}
+
+		if n > 0 {
+			return fmt.Sprintf("%s.~%d~", name, n+1), nil
+		}
+	}
+
+	return name + b.suffix, nil
+}
+
+// lastBackupNumber finds the highest N of the name.~N~ files, or 0.
+func lastBackupNumber(name string) (int, error) {
+	entries, err := os.ReadDir(filepath.Dir(name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+
+		return 0, err
+	}
+
+	re := regexp.MustCompile(`^` + regexp.QuoteMeta(filepath.Base(name)) + `\.~([1-9][0-9]*)~$`)
+	last := 0
+
+	for _, entry := range entries {
+		if m := re.FindStringSubmatch(entry.Name()); m != nil {
+			if n, err := strconv.Atoi(m[1]); err == nil && n > last {
+				last = n
+			}
+		}
+	}
+
+	return last, nil
+}
+
+// makeBackup puts a copy of src at dst. The backup is a hard link when
+// possible, which is instant and needs no space; canLink is false when src
+// is about to be overwritten in place, which would change a link too. The
+// backup appears atomically, so an old backup is never half overwritten.
+func makeBackup(src, dst string, canLink bool) error {
+	dir := filepath.Dir(dst)
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, ".ssed-backup-*")
+	if err != nil {
+		return err
+	}
+
+	tmpName := tmp.Name()
+	tmp.Close()
+
+	linked := false
+
+	if canLink {
+		os.Remove(tmpName)
+
+		linked = os.Link(src, tmpName) == nil
+	}
+
+	if !linked {
+		if err := copyFile(src, tmpName); err != nil {
+			os.Remove(tmpName)
+
+			return err
+		}
+
+		if info, err := os.Stat(src); err == nil {
+			os.Chmod(tmpName, info.Mode().Perm())
+		}
+	}
+
+	if err := os.Rename(tmpName, dst); err != nil {
+		os.Remove(tmpName)
+
+		return err
+	}
+
+	return nil
+}
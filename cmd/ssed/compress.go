@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+)
+
+type compression int
+
+const (
+	compressionNone compression = iota
+	compressionGzip
+	compressionBzip2
+)
+
+func (c compression) String() string {
+	switch c {
+	case compressionGzip:
+		return "gzip"
+	case compressionBzip2:
+		return "bzip2"
+	default:
+		return "none"
+	}
+}
+
+// compressedInput remembers how an input was compressed so that in-place
+// edits can write it back the same way.
+type compressedInput struct {
+	format compression
+	level  int
+	header gzip.Header
+}
+
+var (
+	gzipMagic  = []byte{0x1f, 0x8b}
+	bzip2Magic = []byte("BZh")
+	zstdMagic  = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	xzMagic    = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+)
+
+// decompress sniffs the magic bytes of input and returns a reader for the
+// decompressed data. Uncompressed input is returned as is.
+func decompress(input io.Reader) (io.Reader, *compressedInput, error) {
+	br := bufio.NewReader(input)
+	head, _ := br.Peek(10)
+
+	switch {
+	case bytes.HasPrefix(head, gzipMagic):
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		info := &compressedInput{format: compressionGzip, level: gzipLevel(head), header: zr.Header}
+
+		return zr, info, nil
+	case bytes.HasPrefix(head, bzip2Magic) && len(head) >= 4 && head[3] >= '1' && head[3] <= '9':
+		return bzip2.NewReader(br), &compressedInput{format: compressionBzip2}, nil
+	case bytes.HasPrefix(head, zstdMagic):
+		return nil, nil, fmt.Errorf("zstd-compressed input is not supported; decompress it with zstd -d first")
+	case bytes.HasPrefix(head, xzMagic):
+		return nil, nil, fmt.Errorf("xz-compressed input is not supported; decompress it with xz -d first")
+	default:
+		return br, &compressedInput{format: compressionNone}, nil
+	}
+}
+
+// gzipLevel recovers the compression level from the header's XFL byte, which
+// only distinguishes maximum and fastest compression.
+func gzipLevel(header []byte) int {
+	if len(header) < 9 {
+		return gzip.DefaultCompression
+	}
+
+	switch header[8] {
+	case 2:
+		return gzip.BestCompression
+	case 4:
+		return gzip.BestSpeed
+	default:
+		return gzip.DefaultCompression
+	}
+}
+
+// writable reports whether data can be written back in this format.
+func (c *compressedInput) writable() error {
+	if c.format == compressionBzip2 {
+		return fmt.Errorf("bzip2 files can be read but not written (the standard library has no bzip2 compressor)")
+	}
+
+	return nil
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// writer returns a writer that compresses into w the same way the input was
+// compressed. Closing it flushes the compressor but leaves w open.
+func (c *compressedInput) writer(w io.Writer) (io.WriteCloser, error) {
+	if c.format != compressionGzip {
+		return nopWriteCloser{w}, nil
+	}
+
+	zw, err := gzip.NewWriterLevel(w, c.level)
+	if err != nil {
+		return nil, err
+	}
+
+	zw.Header = c.header
+
+	return zw, nil
+}
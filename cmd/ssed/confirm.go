@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+
+	"github.com/Gx2-Studio/ssed/pkg/diff"
+)
+
+// prompter asks about each change in --confirm mode, like the c flag of
+// Vim's :s. Its state carries across files: "a" accepts everything that
+// follows, "q" rejects it.
+type prompter struct {
+	in    *bufio.Reader
+	out   io.Writer
+	all   bool
+	quit  bool
+	color bool
+}
+
+// openTerminal opens the terminal the answers are read from, so that the
+// input to edit can come from standard input.
+var openTerminal = func() (io.ReadCloser, error) {
+	if runtime.GOOS == "windows" {
+		return os.Open("CONIN$")
+	}
+
+	return os.Open("/dev/tty")
+}
+
+func newPrompter(in io.Reader, out io.Writer, color bool) *prompter {
+	return &prompter{in: bufio.NewReader(in), out: out, color: color}
+}
+
+// confirm shows the change and reads an answer. Running out of input counts
+// as "q", so a closed stdin never applies anything unasked.
+func (p *prompter) confirm(filename string, h diff.Hunk) (bool, error) {
+	if p.all {
+		return true, nil
+	}
+
+	if p.quit {
+		return false, nil
+	}
+
+	fmt.Fprintf(p.out, "%s:%d\n", filename, hunkLine(h))
+
+	for _, text := range diff.FormatLines(h.Lines, p.color) {
+		fmt.Fprintln(p.out, text)
+	}
+
+	for {
+		fmt.Fprint(p.out, "Apply this change? [y,n,a,q] ")
+
+		answer, err := p.in.ReadString('\n')
+		if err != nil && answer == "" {
+			if err == io.EOF {
+				fmt.Fprintln(p.out)
+
+				p.quit = true
+
+				return false, nil
+			}
+
+			return false, err
+		}
+
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "y", "yes":
+			return true, nil
+		case "n", "no":
+			return false, nil
+		case "a", "all":
+			p.all = true
+
+			return true, nil
+		case "q", "quit":
+			p.quit = true
+
+			return false, nil
+		}
+
+		fmt.Fprintln(p.out, "y - apply this change\nn - skip this change\na - apply this and all remaining changes\nq - skip this and all remaining changes")
+	}
+}
+
+// hunkLine is the line of the original file a change starts at. A pure
+// insertion's start is the line before it, so the line after is used.
+func hunkLine(h diff.Hunk) int {
+	if h.OldLines == 0 {
+		return h.OldStart + 1
+	}
+
+	return h.OldStart
+}
+
+// confirmChanges asks about each changed run of lines between content and
+// output and returns content with only the accepted changes applied.
+func confirmChanges(p *prompter, filename, content, output string) (string, error) {
+	old := diff.SplitLines(content)
+	hunks := diff.Hunks(diff.Lines(old, diff.SplitLines(output)), 0)
+
+	var err error
+
+	patched := diff.Patch(old, hunks, func(_ int, h diff.Hunk) bool {
+		if err != nil {
+			return false
+		}
+
+		var ok bool
+
+		ok, err = p.confirm(filename, h)
+
+		return ok
+	})
+	if err != nil {
+		return "", fmt.Errorf("error reading answer: %w", err)
+	}
+
+	return strings.Join(patched, ""), nil
+}
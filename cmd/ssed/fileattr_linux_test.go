@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestCLI_InPlaceKeepsXattrs(t *testing.T) {
+	tmpFile := filepath.Join(t.TempDir(), "test.txt")
+
+	if err := os.WriteFile(tmpFile, []byte("foo\n"), 0o644); err != nil {
+		t.Fatalf("failed to create temp file: %v", err)
+	}
+
+	if err := syscall.Setxattr(tmpFile, "user.ssed-test", []byte("kept"), 0); err != nil {
+		t.Skipf("extended attributes not supported: %v", err)
+	}
+
+	if _, _, err := runSsed("replace foo with bar", tmpFile, "-i", "-q"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	value := make([]byte, 16)
+
+	n, err := syscall.Getxattr(tmpFile, "user.ssed-test", value)
+	if err != nil || string(value[:n]) != "kept" {
+		t.Errorf("expected the xattr to survive, got %q (%v)", value[:n], err)
+	}
+}
+
+func TestCLI_InPlaceKeepsOwner(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("changing the owner of a file needs root")
+	}
+
+	tmpFile := filepath.Join(t.TempDir(), "test.txt")
+
+	if err := os.WriteFile(tmpFile, []byte("foo\n"), 0o644); err != nil {
+		t.Fatalf("failed to create temp file: %v", err)
+	}
+
+	if err := os.Chown(tmpFile, 1234, 5678); err != nil {
+		t.Fatalf("failed to change owner: %v", err)
+	}
+
+	if _, _, err := runSsed("replace foo with bar", tmpFile, "-i", "-q"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	fi, _ := os.Stat(tmpFile)
+	st := fi.Sys().(*syscall.Stat_t)
+
+	if st.Uid != 1234 || st.Gid != 5678 {
+		t.Errorf("expected owner 1234:5678, got %d:%d", st.Uid, st.Gid)
+	}
+}
+
+func TestCLI_InPlaceLockedFile(t *testing.T) {
+	tmpFile := filepath.Join(t.TempDir(), "test.txt")
+
+	if err := os.WriteFile(tmpFile, []byte("foo\n"), 0o644); err != nil {
+		t.Fatalf("failed to create temp file: %v", err)
+	}
+
+	f, err := os.Open(tmpFile)
+	if err != nil {
+		t.Fatalf("failed to open temp file: %v", err)
+	}
+
+	defer f.Close()
+
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		t.Skipf("flock not supported: %v", err)
+	}
+
+	_, _, err = runSsed("replace foo with bar", tmpFile, "-i", "-q")
+	if err == nil || !strings.Contains(err.Error(), "being edited by another process") {
+		t.Fatalf("expected a lock error, got: %v", err)
+	}
+
+	if got, _ := os.ReadFile(tmpFile); string(got) != "foo\n" {
+		t.Errorf("a locked file must not be edited, got %q", got)
+	}
+}
+
+func TestCLI_AtomicAllManyFiles(t *testing.T) {
+	var limit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &limit); err != nil {
+		t.Skipf("cannot read the open file limit: %v", err)
+	}
+
+	dir := t.TempDir()
+	args := []string{"replace foo with bar"}
+
+	for i := 0; i < 200; i++ {
+		name := filepath.Join(dir, fmt.Sprintf("f%03d.txt", i))
+		if err := os.WriteFile(name, []byte("foo\n"), 0o644); err != nil {
+			t.Fatalf("failed to create temp file: %v", err)
+		}
+
+		args = append(args, name)
+	}
+
+	lowered := limit
+	lowered.Cur = 64
+
+	if err := syscall.Setrlimit(syscall.RLIMIT_NOFILE, &lowered); err != nil {
+		t.Skipf("cannot lower the open file limit: %v", err)
+	}
+
+	_, _, err := runSsed(append(args, "-i", "-q", "--atomic-all")...)
+
+	syscall.Setrlimit(syscall.RLIMIT_NOFILE, &limit)
+
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, name := range args[1:] {
+		if got, _ := os.ReadFile(name); string(got) != "bar\n" {
+			t.Fatalf("expected %s to be changed, got %q", name, got)
+		}
+	}
+}
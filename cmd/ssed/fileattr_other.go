@@ -0,0 +1,15 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+func linkCount(os.FileInfo) uint64 {
+	return 1
+}
+
+func copyOwner(*os.File, os.FileInfo) {}
+
+func lockFile(*os.File) error {
+	return nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/Gx2-Studio/ssed/pkg/diff"
+	"github.com/Gx2-Studio/ssed/pkg/executor"
+)
+
+// Colours of the lines show prints, as in GNU grep.
+const (
+	colorMatch     = "\x1b[1;31m"
+	colorFilename  = "\x1b[35m"
+	colorLineNum   = "\x1b[32m"
+	colorSeparator = "\x1b[36m"
+)
+
+// matchFormatter returns the formatter for the lines show selects from
+// filename. It puts grep-style prefixes in front of them: -H adds the file
+// name, -n the line number in the original input and --column the 1-based
+// byte column of the first match, giving the file:line:col: format of Vim's
+// and Emacs's grep modes. With colour on, it also highlights the matches.
+// It returns nil when there is nothing to do.
+func matchFormatter(filename string, opts options) func(executor.Match) string {
+	if !opts.withFilename && !opts.lineNumber && !opts.column && !opts.colored {
+		return nil
+	}
+
+	paint := func(b *strings.Builder, code, text string) {
+		if opts.colored {
+			b.WriteString(code + text + diff.ColorReset)
+		} else {
+			b.WriteString(text)
+		}
+	}
+
+	return func(m executor.Match) string {
+		var b strings.Builder
+
+		if opts.withFilename {
+			paint(&b, colorFilename, filename)
+			paint(&b, colorSeparator, ":")
+		}
+
+		if opts.lineNumber || opts.column {
+			paint(&b, colorLineNum, strconv.Itoa(m.Line))
+			paint(&b, colorSeparator, ":")
+		}
+
+		if opts.column {
+			// Lines selected by position have no match; they start at 1.
+			col := 1
+			if len(m.Spans) > 0 {
+				col = m.Spans[0].Start + 1
+			}
+
+			paint(&b, colorLineNum, strconv.Itoa(col))
+			paint(&b, colorSeparator, ":")
+		}
+
+		if !opts.colored {
+			b.WriteString(m.Text)
+
+			return b.String()
+		}
+
+		last := 0
+
+		for _, span := range m.Spans {
+			b.WriteString(m.Text[last:span.Start])
+			paint(&b, colorMatch, m.Text[span.Start:span.End])
+			last = span.End
+		}
+
+		b.WriteString(m.Text[last:])
+
+		return b.String()
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+var errLocked = errors.New("locked by another process")
+
+// fileGuard notices when another process changes a file while ssed edits
+// it. It remembers the file's identity, size and mtime (and, if asked, a
+// hash of its content) when the edit starts and compares them again just
+// before the new content is moved into place. It also holds an advisory
+// lock on the file, which keeps other ssed runs and flock-aware tools out.
+type fileGuard struct {
+	name string // with symlinks resolved
+	info os.FileInfo
+	hash string
+	lock *os.File
+}
+
+func newFileGuard(filename string, withHash bool) (*fileGuard, error) {
+	target, err := filepath.EvalSymlinks(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	g := &fileGuard{name: target}
+
+	if err := g.acquire(); err != nil {
+		return nil, err
+	}
+
+	// Take the snapshot under the lock.
+	g.info, err = g.lock.Stat()
+	if err == nil && withHash {
+		g.hash, err = hashFile(target)
+	}
+
+	if err != nil {
+		g.release()
+
+		return nil, err
+	}
+
+	return g, nil
+}
+
+// acquire takes the advisory lock.
+func (g *fileGuard) acquire() error {
+	f, err := os.Open(g.name)
+	if err != nil {
+		return err
+	}
+
+	if err := lockFile(f); err != nil {
+		f.Close()
+
+		if errors.Is(err, errLocked) {
+			return fmt.Errorf("%s is being edited by another process", g.name)
+		}
+
+		return fmt.Errorf("error locking %s: %w", g.name, err)
+	}
+
+	g.lock = f
+
+	return nil
+}
+
+func (g *fileGuard) release() {
+	if g != nil && g.lock != nil {
+		g.lock.Close()
+		g.lock = nil
+	}
+}
+
+// verify fails if the file is no longer the one the edit started from.
+func (g *fileGuard) verify() error {
+	changed := fmt.Errorf("%s was changed by another process during the edit; not overwriting it", g.name)
+
+	info, err := os.Stat(g.name)
+	if err != nil {
+		return changed
+	}
+
+	if !os.SameFile(info, g.info) || info.Size() != g.info.Size() || !info.ModTime().Equal(g.info.ModTime()) {
+		return changed
+	}
+
+	if g.hash != "" {
+		sum, err := hashFile(g.name)
+		if err != nil || sum != g.hash {
+			return changed
+		}
+	}
+
+	return nil
+}
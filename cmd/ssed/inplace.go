@@ -0,0 +1,281 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// pendingWrite is a temporary file next to the file it will replace. It has
+// the owner, permissions and extended attributes of the original and
+// hashes what is written to it, for the undo journal.
+type pendingWrite struct {
+	name    string // as given on the command line
+	target  string // name with symlinks resolved
+	info    os.FileInfo
+	inPlace bool // several hard links: copy over the target instead of renaming
+	temp    string
+	file    *os.File
+	sum     hash.Hash
+	guard   *fileGuard
+}
+
+// newPendingWrite creates the temporary file for replacing filename. A
+// symlink is followed so that its target is edited and the link survives.
+func newPendingWrite(filename string) (*pendingWrite, error) {
+	target, err := filepath.EvalSymlinks(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(target)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(target), ".ssed-*")
+	if err != nil {
+		return nil, err
+	}
+
+	p := &pendingWrite{
+		name:    filename,
+		target:  target,
+		info:    info,
+		inPlace: linkCount(info) > 1,
+		temp:    f.Name(),
+		file:    f,
+		sum:     sha256.New(),
+	}
+
+	// Change the owner first: chown clears the setuid and setgid bits.
+	copyOwner(f, info)
+
+	if err := f.Chmod(info.Mode()); err != nil {
+		p.discard()
+
+		return nil, err
+	}
+
+	if err := copyXattrs(target, p.temp); err != nil {
+		p.discard()
+
+		return nil, fmt.Errorf("error copying extended attributes: %w", err)
+	}
+
+	return p, nil
+}
+
+func (p *pendingWrite) Write(b []byte) (int, error) {
+	n, err := p.file.Write(b)
+	p.sum.Write(b[:n])
+
+	return n, err
+}
+
+// hash is the SHA-256 of the content written so far.
+func (p *pendingWrite) hash() string {
+	return hex.EncodeToString(p.sum.Sum(nil))
+}
+
+func (p *pendingWrite) close() error {
+	if p.file == nil {
+		return nil
+	}
+
+	err := p.file.Close()
+	p.file = nil
+
+	return err
+}
+
+// discard removes the temporary file.
+func (p *pendingWrite) discard() {
+	p.close()
+	os.Remove(p.temp)
+}
+
+// commit moves the new content over the target. A file with several hard
+// links is overwritten in place instead, which keeps all its names pointing
+// at the same file at the price of atomicity.
+func (p *pendingWrite) commit() error {
+	if err := p.close(); err != nil {
+		p.discard()
+
+		return err
+	}
+
+	return replaceWith(p.temp, p.target, p.inPlace)
+}
+
+// replaceWith moves tempName over target, or with inPlace copies its content
+// into target and removes it.
+func replaceWith(tempName, target string, inPlace bool) error {
+	if !inPlace {
+		if err := os.Rename(tempName, target); err != nil {
+			os.Remove(tempName)
+
+			return err
+		}
+
+		return nil
+	}
+
+	defer os.Remove(tempName)
+
+	src, err := os.Open(tempName)
+	if err != nil {
+		return err
+	}
+
+	defer src.Close()
+
+	dst, err := os.OpenFile(target, os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+
+		return err
+	}
+
+	if err := dst.Sync(); err != nil {
+		dst.Close()
+
+		return err
+	}
+
+	return dst.Close()
+}
+
+// atomicWriteFile replaces the content of filename with data, keeping its
+// attributes and links as described for pendingWrite.
+func atomicWriteFile(filename string, data []byte) error {
+	p, err := newPendingWrite(filename)
+	if err != nil {
+		return err
+	}
+
+	if _, err := p.Write(data); err != nil {
+		p.discard()
+
+		return err
+	}
+
+	return p.commit()
+}
+
+// writeInPlace replaces filename with what produce writes, compressed like
+// the input. The output is streamed to the temporary file, so memory use
+// doesn't depend on the size of the file. produce reports whether the
+// content changed; if it didn't, the file is left alone so its mtime and
+// backups stay as they are.
+func writeInPlace(filename string, compressed *compressedInput, guard *fileGuard, stderr io.Writer, opts options, produce func(io.Writer) (bool, error)) (bool, error) {
+	p, err := newPendingWrite(filename)
+	if err != nil {
+		return false, fmt.Errorf("error writing file %s: %w", filename, err)
+	}
+
+	p.guard = guard
+
+	zw, err := compressed.writer(p)
+	if err != nil {
+		p.discard()
+
+		return false, fmt.Errorf("error compressing file %s: %w", filename, err)
+	}
+
+	changed, err := produce(zw)
+	if err != nil || !changed {
+		p.discard()
+
+		return false, err
+	}
+
+	err = zw.Close()
+	if err == nil {
+		err = p.close()
+	}
+
+	if err != nil {
+		p.discard()
+
+		return false, fmt.Errorf("error writing file %s: %w", filename, err)
+	}
+
+	if opts.transaction != nil {
+		opts.transaction.stage(p)
+
+		return true, nil
+	}
+
+	if guard != nil {
+		if err := guard.verify(); err != nil {
+			p.discard()
+
+			return false, err
+		}
+	}
+
+	if err := saveOriginal(filename, p.target, !p.inPlace, p.hash(), opts, stderr); err != nil {
+		p.discard()
+
+		return false, err
+	}
+
+	if err := p.commit(); err != nil {
+		return false, fmt.Errorf("error writing file %s: %w", filename, err)
+	}
+
+	if opts.preserveMtime {
+		if err := preserveMtime(p.target, p.info); err != nil {
+			return true, fmt.Errorf("error setting the modification time of %s: %w", filename, err)
+		}
+	}
+
+	if !opts.quiet {
+		fmt.Fprintf(stderr, "Modified: %s\n", filename)
+	}
+
+	return true, nil
+}
+
+// saveOriginal makes the backup and the undo journal entry for filename,
+// whose current content is in src, before it is replaced by content with
+// the given hash. canLink says whether the backup may be a hard link to src.
+func saveOriginal(filename, src string, canLink bool, hash string, opts options, stderr io.Writer) error {
+	if opts.backups.control != backupNone {
+		backupName, err := opts.backups.path(filename)
+		if err == nil {
+			err = makeBackup(src, backupName, canLink)
+		}
+
+		if err != nil {
+			return fmt.Errorf("error creating backup of %s: %w", filename, err)
+		}
+
+		if !opts.quiet {
+			fmt.Fprintf(stderr, "Backup created: %s\n", backupName)
+		}
+	}
+
+	if opts.journal != nil {
+		if err := opts.journal.record(filename, src, canLink, hash); err != nil {
+			fmt.Fprintf(stderr, "Warning: error recording %s in the undo journal, not recording this run any further: %v\n", filename, err)
+		}
+	}
+
+	return nil
+}
+
+// preserveMtime sets the modification time of filename back to that in info.
+func preserveMtime(filename string, info os.FileInfo) error {
+	return os.Chtimes(filename, time.Time{}, info.ModTime())
+}
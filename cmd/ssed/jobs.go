@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"runtime"
+
+	"github.com/Gx2-Studio/ssed/pkg/ast"
+	"github.com/Gx2-Studio/ssed/pkg/executor"
+)
+
+type fileStatus int
+
+const (
+	fileUnchanged fileStatus = iota
+	fileModified
+	fileSkipped
+)
+
+// fileReport is what processing one file produced, for the final summary.
+type fileReport struct {
+	name     string
+	status   fileStatus
+	inserted int
+	deleted  int
+	// matches counts the records selected by a show or count query.
+	matches int
+}
+
+type fileResult struct {
+	stdout bytes.Buffer
+	stderr bytes.Buffer
+	report fileReport
+	err    error
+}
+
+// runFiles processes every file, up to opts.jobs at a time. Output is
+// buffered per file and written in argument order, so it doesn't depend on
+// which worker finishes first. A failing file doesn't stop the others.
+func runFiles(command ast.Command, filenames []string, stdout, stderr io.Writer, opts options, execOpts executor.Options) error {
+	if len(filenames) == 1 {
+		report, err := processFile(command, filenames[0], stdout, stderr, opts, execOpts)
+		if err == nil && opts.transaction != nil {
+			err = opts.transaction.commit(opts, stderr)
+		}
+
+		if err != nil {
+			opts.transaction.rollback()
+
+			return err
+		}
+
+		if opts.stat {
+			writeDiffStat(stdout, []fileReport{report})
+		}
+
+		return outcome(command, opts, []fileReport{report})
+	}
+
+	var modified, skipped, failed int
+
+	var reports []fileReport
+
+	var firstFailed string
+
+	tally := func(report fileReport, err error) {
+		switch {
+		case err != nil:
+			if failed == 0 {
+				firstFailed = report.name
+			}
+
+			failed++
+
+			fmt.Fprintf(stderr, "Error: %v\n", err)
+		case report.status == fileModified:
+			modified++
+		case report.status == fileSkipped:
+			skipped++
+		}
+
+		reports = append(reports, report)
+	}
+
+	jobs := opts.jobs
+	if jobs <= 0 {
+		jobs = runtime.NumCPU()
+	}
+
+	if jobs == 1 {
+		for _, filename := range filenames {
+			tally(processFile(command, filename, stdout, stderr, opts, execOpts))
+		}
+	} else {
+		runParallel(command, filenames, min(jobs, len(filenames)), stdout, stderr, opts, execOpts, tally)
+	}
+
+	if opts.transaction != nil {
+		if failed > 0 {
+			opts.transaction.rollback()
+
+			modified = 0
+		} else if err := opts.transaction.commit(opts, stderr); err != nil {
+			return err
+		}
+	}
+
+	if opts.stat {
+		writeDiffStat(stdout, reports)
+	}
+
+	if !opts.quiet && (opts.inPlace || failed > 0) {
+		fmt.Fprintf(stderr, "Processed %d files: %d modified, %d skipped, %d failed\n",
+			len(filenames), modified, skipped, failed)
+	}
+
+	if failed > 0 && opts.transaction != nil {
+		return fmt.Errorf("aborted because %s failed; no files were changed", firstFailed)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d files failed", failed, len(filenames))
+	}
+
+	return outcome(command, opts, reports)
+}
+
+// outcome turns a successful run into grep-style exit codes: a show or
+// count query that matched nothing exits 1, and so does --check when a file
+// would change.
+func outcome(command ast.Command, opts options, reports []fileReport) error {
+	if opts.check {
+		changed := 0
+
+		for _, r := range reports {
+			if r.status == fileModified {
+				changed++
+			}
+		}
+
+		if changed == 0 {
+			return nil
+		}
+
+		status := &exitStatus{code: exitNoMatch}
+		if !opts.quiet {
+			status.msg = fmt.Sprintf("%d %s would change", changed, plural(changed, "file", "files"))
+		}
+
+		return status
+	}
+
+	if !isQuery(command) {
+		return nil
+	}
+
+	for _, r := range reports {
+		if r.matches > 0 {
+			return nil
+		}
+	}
+
+	return &exitStatus{code: exitNoMatch}
+}
+
+// isQuery reports whether command ends in show or count, whose output is
+// the records that matched.
+func isQuery(command ast.Command) bool {
+	if compound, ok := command.(*ast.CompoundCommand); ok && len(compound.Commands) > 0 {
+		return isQuery(compound.Commands[len(compound.Commands)-1])
+	}
+
+	switch command.(type) {
+	case *ast.ShowCommand, *ast.CountCommand:
+		return true
+	default:
+		return false
+	}
+}
+
+// runParallel runs the files on a pool of workers and copies each file's
+// output, then reports its result, once it and all files before it are done.
+func runParallel(command ast.Command, filenames []string, jobs int, stdout, stderr io.Writer, opts options, execOpts executor.Options, report func(fileReport, error)) {
+	results := make([]chan *fileResult, len(filenames))
+	for i := range results {
+		results[i] = make(chan *fileResult, 1)
+	}
+
+	work := make(chan int)
+
+	for w := 0; w < jobs; w++ {
+		go func() {
+			for i := range work {
+				r := &fileResult{}
+				r.report, r.err = processFile(command, filenames[i], &r.stdout, &r.stderr, opts, execOpts)
+				results[i] <- r
+			}
+		}()
+	}
+
+	go func() {
+		for i := range filenames {
+			work <- i
+		}
+
+		close(work)
+	}()
+
+	for i := range filenames {
+		r := <-results[i]
+
+		_, _ = stdout.Write(r.stdout.Bytes())
+		_, _ = stderr.Write(r.stderr.Bytes())
+
+		report(r.report, r.err)
+	}
+}
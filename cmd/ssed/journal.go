@@ -0,0 +1,315 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maxJournalRuns is how many in-place runs are kept for undo.
+const maxJournalRuns = 100
+
+// journalEntry is one file changed by a run. The original content is stored
+// next to the journal; Hash is the content ssed wrote, so undo can tell
+// whether the file was touched since.
+type journalEntry struct {
+	Path     string `json:"path"`
+	Original string `json:"original"`
+	Hash     string `json:"hash"`
+}
+
+type journalRun struct {
+	ID     string         `json:"id"`
+	Time   time.Time      `json:"time"`
+	Query  string         `json:"query"`
+	Dir    string         `json:"dir"`
+	Files  []journalEntry `json:"files"`
+	Undone *time.Time     `json:"undone,omitempty"`
+}
+
+// journal records the originals of the files an -i run modifies. It is
+// safe for concurrent use by the -j workers.
+type journal struct {
+	mu     sync.Mutex
+	dir    string
+	run    journalRun
+	failed bool
+}
+
+// stateDir is where journals are kept: $SSED_STATE_DIR, else ssed under
+// $XDG_STATE_HOME or ~/.local/state.
+func stateDir() (string, error) {
+	if dir := os.Getenv("SSED_STATE_DIR"); dir != "" {
+		return dir, nil
+	}
+
+	if dir := os.Getenv("XDG_STATE_HOME"); dir != "" {
+		return filepath.Join(dir, "ssed"), nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("cannot find a state directory for the undo journal: %w", err)
+	}
+
+	return filepath.Join(home, ".local", "state", "ssed"), nil
+}
+
+func hashFile(filename string) (string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// newJournal prepares a journal for a run of query. Nothing is written until
+// the first file is recorded, so runs that change nothing leave no trace.
+func newJournal(query string) (*journal, error) {
+	root, err := stateDir()
+	if err != nil {
+		return nil, err
+	}
+
+	var suffix [3]byte
+	if _, err := rand.Read(suffix[:]); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	id := now.Format("20060102-150405") + "-" + hex.EncodeToString(suffix[:])
+	cwd, _ := os.Getwd()
+
+	return &journal{
+		dir: filepath.Join(root, "runs", id),
+		run: journalRun{ID: id, Time: now, Query: query, Dir: cwd},
+	}, nil
+}
+
+// record saves the current content of filename, which is in src, before it
+// is replaced by content with the given hash. canLink says whether src is
+// replaced by rename, so the journal may keep a hard link to it instead of
+// a copy. Call it before writing the file. After the first error the
+// journal stops recording, so the error is only reported once.
+func (j *journal) record(filename, src string, canLink bool, hash string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.failed {
+		return nil
+	}
+
+	err := j.add(filename, src, canLink, hash)
+	if err != nil {
+		j.failed = true
+	}
+
+	return err
+}
+
+func (j *journal) add(filename, src string, canLink bool, hash string) error {
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(j.dir, 0o700); err != nil {
+		return err
+	}
+
+	name := strconv.Itoa(len(j.run.Files)) + ".orig"
+	dst := filepath.Join(j.dir, name)
+
+	// Like makeBackup, keep the old inode when nothing writes to it any
+	// more, and copy only when linking isn't possible.
+	if !canLink || os.Link(src, dst) != nil {
+		if err := copyFile(src, dst); err != nil {
+			return err
+		}
+	}
+
+	j.run.Files = append(j.run.Files, journalEntry{Path: abs, Original: name, Hash: hash})
+
+	return writeJournalRun(j.dir, &j.run)
+}
+
+// close prunes the oldest runs once a run has been recorded.
+func (j *journal) close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if len(j.run.Files) == 0 {
+		return nil
+	}
+
+	runs, err := os.ReadDir(filepath.Dir(j.dir))
+	if err != nil {
+		return err
+	}
+
+	// Run IDs start with a timestamp, so ReadDir's order is oldest first.
+	for i := 0; i < len(runs)-maxJournalRuns; i++ {
+		if err := os.RemoveAll(filepath.Join(filepath.Dir(j.dir), runs[i].Name())); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func writeJournalRun(dir string, run *journalRun) error {
+	data, err := json.MarshalIndent(run, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return atomicWriteNewFile(filepath.Join(dir, "journal.json"), data, 0o600)
+}
+
+// atomicWriteNewFile is atomicWriteFile for files that may not exist yet.
+func atomicWriteNewFile(filename string, data []byte, perm os.FileMode) error {
+	if _, err := os.Stat(filename); errors.Is(err, os.ErrNotExist) {
+		if err := os.WriteFile(filename, nil, perm); err != nil {
+			return err
+		}
+	}
+
+	return atomicWriteFile(filename, data)
+}
+
+// readJournalRuns returns the recorded runs, newest first.
+func readJournalRuns() (string, []journalRun, error) {
+	root, err := stateDir()
+	if err != nil {
+		return "", nil, err
+	}
+
+	runsDir := filepath.Join(root, "runs")
+
+	entries, err := os.ReadDir(runsDir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return "", nil, err
+	}
+
+	var runs []journalRun
+
+	for _, entry := range entries {
+		data, err := os.ReadFile(filepath.Join(runsDir, entry.Name(), "journal.json"))
+		if err != nil {
+			continue // a run that never recorded a file
+		}
+
+		var run journalRun
+		if err := json.Unmarshal(data, &run); err != nil {
+			return "", nil, fmt.Errorf("corrupt journal %s: %w", entry.Name(), err)
+		}
+
+		runs = append(runs, run)
+	}
+
+	sort.SliceStable(runs, func(a, b int) bool { return runs[a].Time.After(runs[b].Time) })
+
+	return runsDir, runs, nil
+}
+
+func runHistory(stdout io.Writer) error {
+	_, runs, err := readJournalRuns()
+	if err != nil {
+		return err
+	}
+
+	if len(runs) == 0 {
+		fmt.Fprintln(stdout, "No in-place edits recorded")
+
+		return nil
+	}
+
+	for _, run := range runs {
+		status := ""
+		if run.Undone != nil {
+			status = " (undone)"
+		}
+
+		fmt.Fprintf(stdout, "%s  %s  %d %s  %q%s\n", run.ID, run.Time.Format("2006-01-02 15:04:05"),
+			len(run.Files), plural(len(run.Files), "file", "files"), run.Query, status)
+	}
+
+	return nil
+}
+
+// runUndo restores the files of run id, or of the latest run not undone yet.
+// Nothing is restored if any of the files changed after the run.
+func runUndo(id string, stderr io.Writer) error {
+	runsDir, runs, err := readJournalRuns()
+	if err != nil {
+		return err
+	}
+
+	var run *journalRun
+
+	for i := range runs {
+		if (id == "" && runs[i].Undone == nil) || runs[i].ID == id {
+			run = &runs[i]
+
+			break
+		}
+	}
+
+	switch {
+	case run == nil && id != "":
+		return fmt.Errorf("no recorded run %s (see ssed history)", id)
+	case run == nil:
+		return fmt.Errorf("no in-place edits to undo")
+	case run.Undone != nil:
+		return fmt.Errorf("run %s was already undone", run.ID)
+	}
+
+	for _, entry := range run.Files {
+		current, err := hashFile(entry.Path)
+		if err != nil {
+			return fmt.Errorf("cannot undo run %s: %w", run.ID, err)
+		}
+
+		if current != entry.Hash {
+			return fmt.Errorf("cannot undo run %s: %s has changed since", run.ID, entry.Path)
+		}
+	}
+
+	dir := filepath.Join(runsDir, run.ID)
+
+	for _, entry := range run.Files {
+		original, err := os.ReadFile(filepath.Join(dir, entry.Original))
+		if err != nil {
+			return fmt.Errorf("error reading journal %s: %w", run.ID, err)
+		}
+
+		if err := atomicWriteFile(entry.Path, original); err != nil {
+			return fmt.Errorf("error restoring %s: %w", entry.Path, err)
+		}
+
+		fmt.Fprintf(stderr, "Restored: %s\n", entry.Path)
+	}
+
+	now := time.Now()
+	run.Undone = &now
+
+	return writeJournalRun(dir, run)
+}
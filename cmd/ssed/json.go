@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+
+	"github.com/Gx2-Studio/ssed/pkg/diff"
+	"github.com/Gx2-Studio/ssed/pkg/executor"
+)
+
+// jsonMatch is one match of a show or count query in --json output. Column
+// is a 1-based byte offset; lines selected by position have no column or
+// match.
+type jsonMatch struct {
+	File   string `json:"file"`
+	Line   int    `json:"line"`
+	Column int    `json:"column,omitempty"`
+	Text   string `json:"text"`
+	Match  string `json:"match,omitempty"`
+}
+
+// jsonChange is a run of changed lines: Line is where it starts in the
+// original file, Before the lines it replaces and After the new ones.
+type jsonChange struct {
+	Line   int      `json:"line"`
+	Before []string `json:"before"`
+	After  []string `json:"after"`
+}
+
+// jsonFile is the --json record of what an editing query did to one file.
+type jsonFile struct {
+	File    string       `json:"file"`
+	Changes []jsonChange `json:"changes"`
+}
+
+// newJSONEncoder writes one object per line, leaving <, > and & alone.
+func newJSONEncoder(w io.Writer) *json.Encoder {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+
+	return enc
+}
+
+// writeJSONMatch writes an object for each span of m, or a single one if it
+// has none.
+func writeJSONMatch(enc *json.Encoder, filename string, m executor.Match) error {
+	if len(m.Spans) == 0 {
+		return enc.Encode(jsonMatch{File: filename, Line: m.Line, Text: m.Text})
+	}
+
+	for _, span := range m.Spans {
+		err := enc.Encode(jsonMatch{
+			File:   filename,
+			Line:   m.Line,
+			Column: span.Start + 1,
+			Text:   m.Text,
+			Match:  m.Text[span.Start:span.End],
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeJSONChanges writes the record of the changes between content and
+// output, if there are any.
+func writeJSONChanges(enc *json.Encoder, filename, content, output string) error {
+	record := jsonFile{File: filename, Changes: []jsonChange{}}
+	script := diff.Lines(diff.SplitLines(content), diff.SplitLines(output))
+
+	for _, h := range diff.Hunks(script, 0) {
+		change := jsonChange{Line: hunkLine(h), Before: []string{}, After: []string{}}
+
+		for _, line := range h.Lines {
+			text := strings.TrimSuffix(strings.TrimSuffix(line.Text, "\n"), "\r")
+
+			if line.Kind == diff.OpDelete {
+				change.Before = append(change.Before, text)
+			} else {
+				change.After = append(change.After, text)
+			}
+		}
+
+		record.Changes = append(record.Changes, change)
+	}
+
+	if len(record.Changes) == 0 {
+		return nil
+	}
+
+	return enc.Encode(record)
+}
@@ -3,15 +3,19 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"crypto/sha256"
+	"errors"
 	"fmt"
 	"io"
 	"os"
-	"path/filepath"
+	"regexp"
 	"strings"
 
 	mmap "github.com/edsrzf/mmap-go"
 	"github.com/spf13/cobra"
 
+	"github.com/Gx2-Studio/ssed/pkg/ast"
+	"github.com/Gx2-Studio/ssed/pkg/diff"
 	"github.com/Gx2-Studio/ssed/pkg/executor"
 	"github.com/Gx2-Studio/ssed/pkg/lexer"
 	"github.com/Gx2-Studio/ssed/pkg/parser"
@@ -21,6 +25,8 @@ var version = "0.1.0"
 
 const mmapThreshold = 1 * 1024 * 1024 // use mmap for files larger than 1MB
 
+const binaryCheckSize = 8 * 1024 // sniff this much of each input for binary data
+
 type mmapReader struct {
 	data   mmap.MMap
 	reader *bytes.Reader
@@ -61,15 +67,87 @@ func (m *mmapReader) Close() error {
 	return m.file.Close()
 }
 
+// Exit codes follow grep: 1 means nothing matched (or, with --check, that
+// something would change) and 2 that something went wrong.
+const (
+	exitNoMatch = 1
+	exitError   = 2
+)
+
+// exitStatus is returned by Run for outcomes that aren't errors but still
+// need a non-zero exit code. msg, if set, is printed as is.
+type exitStatus struct {
+	code int
+	msg  string
+}
+
+func (e *exitStatus) Error() string {
+	if e.msg != "" {
+		return e.msg
+	}
+
+	return fmt.Sprintf("exit status %d", e.code)
+}
+
 func main() {
-	if err := Run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr); err != nil {
-		os.Exit(1)
+	err := Run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr)
+	if err == nil {
+		return
+	}
+
+	var status *exitStatus
+	if errors.As(err, &status) {
+		if status.msg != "" {
+			fmt.Fprintln(os.Stderr, status.msg)
+		}
+
+		os.Exit(status.code)
 	}
+
+	fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+	os.Exit(exitError)
+}
+
+type options struct {
+	preview       string
+	diff          bool
+	stat          bool
+	context       int
+	inPlace       bool
+	confirm       bool
+	prompt        *prompter
+	backup        string
+	suffix        string
+	backupDir     string
+	backups       backupOptions
+	preserveMtime bool
+	verifyHash    bool
+	noJournal     bool
+	journal       *journal
+	atomicAll     bool
+	transaction   *transaction
+	quiet         bool
+	check         bool
+	json          bool
+	withFilename  bool
+	lineNumber    bool
+	column        bool
+	color         string
+	colored       bool
+	lineEndings   string
+	nullData      bool
+	recordSep     string
+	encoding      string
+	binary        string
+	recursive     bool
+	include       []string
+	exclude       []string
+	noIgnore      bool
+	jobs          int
 }
 
 func Run(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
-	var preview, inPlace, quiet bool
-	var backup string
+	var opts options
 
 	rootCmd := &cobra.Command{
 		Use:   "ssed <query> [file...]",
@@ -87,7 +165,7 @@ Examples:
 		SilenceUsage:  true,
 		SilenceErrors: true,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return runQuery(args, stdin, stdout, stderr, preview, inPlace, backup, quiet)
+			return runQuery(args, stdin, stdout, stderr, opts)
 		},
 	}
 
@@ -124,19 +202,72 @@ Insert text:
   ssed "insert footer last" README.md
 
 Options:
-  ssed "replace foo with bar" file.txt --preview    # Preview changes
+  ssed "replace foo with bar" file.txt --preview    # Preview changes as a diff
   ssed "replace foo with bar" file.txt -i           # Edit in-place
   ssed "replace foo with bar" file.txt -i --backup .bak  # With backup`)
 		},
 	}
 
+	historyCmd := &cobra.Command{
+		Use:   "history",
+		Short: "List recorded in-place edits",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runHistory(stdout)
+		},
+	}
+
+	undoCmd := &cobra.Command{
+		Use:   "undo [run-id]",
+		Short: "Restore the files changed by an in-place edit (default: the latest)",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id := ""
+			if len(args) == 1 {
+				id = args[0]
+			}
+
+			return runUndo(id, stderr)
+		},
+	}
+
 	rootCmd.AddCommand(versionCmd)
 	rootCmd.AddCommand(examplesCmd)
-
-	rootCmd.Flags().BoolVarP(&preview, "preview", "p", false, "Preview changes without applying")
-	rootCmd.Flags().BoolVarP(&inPlace, "in-place", "i", false, "Edit files in-place")
-	rootCmd.Flags().StringVarP(&backup, "backup", "b", "", "Backup suffix for in-place editing (e.g., .bak)")
-	rootCmd.Flags().BoolVarP(&quiet, "quiet", "q", false, "Suppress output (only show errors)")
+	rootCmd.AddCommand(historyCmd)
+	rootCmd.AddCommand(undoCmd)
+
+	rootCmd.Flags().StringVarP(&opts.preview, "preview", "p", "", "Preview changes without applying: diff (default) or full")
+	rootCmd.Flags().Lookup("preview").NoOptDefVal = "diff"
+	rootCmd.Flags().BoolVar(&opts.diff, "diff", false, "Show changes as a unified diff (same as --preview=diff)")
+	rootCmd.Flags().BoolVar(&opts.stat, "stat", false, "Show a diffstat summary of the changes instead of the diff")
+	rootCmd.Flags().IntVarP(&opts.context, "context", "U", 3, "Lines of context in diffs")
+	rootCmd.Flags().BoolVarP(&opts.inPlace, "in-place", "i", false, "Edit files in-place")
+	rootCmd.Flags().BoolVar(&opts.confirm, "confirm", false, "Edit files in-place, asking before each change (y/n/a/q)")
+	rootCmd.Flags().StringVarP(&opts.backup, "backup", "b", "", "Back up edited files: a suffix (e.g., .bak) or simple, numbered, or existing")
+	rootCmd.Flags().StringVar(&opts.suffix, "suffix", "~", "Suffix for --backup=simple")
+	rootCmd.Flags().StringVar(&opts.backupDir, "backup-dir", "", "Put backups in a tree under this directory that mirrors the edited files")
+	rootCmd.Flags().BoolVar(&opts.atomicAll, "atomic-all", false, "With -i, change either every file or none: files are replaced only once all succeeded")
+	rootCmd.Flags().BoolVar(&opts.preserveMtime, "preserve-mtime", false, "With -i, keep the modification time of edited files")
+	rootCmd.Flags().BoolVar(&opts.verifyHash, "verify-hash", false, "With -i, also compare file hashes to detect changes made by other processes during the edit")
+	rootCmd.Flags().BoolVar(&opts.noJournal, "no-journal", false, "Don't record in-place edits for ssed undo")
+	rootCmd.Flags().BoolVar(&opts.check, "check", false, "Don't write anything; list the files the query would change and exit 1 if there are any")
+	rootCmd.Flags().BoolVar(&opts.json, "json", false, "Print matches, or the changed lines of each file, as JSON objects, one per line")
+	rootCmd.Flags().BoolVarP(&opts.withFilename, "with-filename", "H", false, "Prefix the lines show prints with their file name")
+	rootCmd.Flags().BoolVarP(&opts.lineNumber, "line-number", "n", false, "Prefix the lines show prints with their line number in the input")
+	rootCmd.Flags().BoolVar(&opts.column, "column", false, "Prefix the lines show prints with line and column of the first match (file:line:col: with -H)")
+	rootCmd.Flags().StringVar(&opts.color, "color", "auto", "Highlight matches, changes and prefixes: auto (on terminals), always, or never")
+	rootCmd.Flags().Lookup("color").NoOptDefVal = "auto"
+	rootCmd.Flags().BoolVarP(&opts.quiet, "quiet", "q", false, "Suppress output (only show errors)")
+	rootCmd.Flags().StringVar(&opts.lineEndings, "line-endings", "keep", "Output line endings: keep, lf, or crlf")
+	rootCmd.Flags().StringVar(&opts.encoding, "encoding", "auto", "Input encoding: auto (BOM detection), utf-8, utf-16le, utf-16be, latin1, or windows-1252")
+	rootCmd.Flags().IntVarP(&opts.jobs, "jobs", "j", 1, "Number of files to process in parallel (0 = one per CPU)")
+	rootCmd.Flags().BoolVarP(&opts.recursive, "recursive", "r", false, "Process directories recursively")
+	rootCmd.Flags().StringArrayVar(&opts.include, "include", nil, "With -r, only process files matching this glob (repeatable)")
+	rootCmd.Flags().StringArrayVar(&opts.exclude, "exclude", nil, "With -r, skip files and directories matching this glob (repeatable)")
+	rootCmd.Flags().BoolVar(&opts.noIgnore, "no-ignore", false, "With -r, don't honour .gitignore and .ignore files")
+	rootCmd.Flags().StringVar(&opts.binary, "binary", "skip", "What to do with binary files: skip, text, or error")
+	rootCmd.Flags().BoolVarP(&opts.nullData, "null-data", "z", false, "Records are separated by NUL bytes instead of newlines")
+	rootCmd.Flags().StringVar(&opts.recordSep, "record-separator", "", "Record separator: a string (\\n, \\t, \\0 escapes) or /regex/; a /^regex/ starts a new record at each line it matches")
 
 	rootCmd.SetArgs(args)
 	rootCmd.SetIn(stdin)
@@ -146,181 +277,453 @@ Options:
 	return rootCmd.Execute()
 }
 
-func runQuery(args []string, stdin io.Reader, stdout, stderr io.Writer, preview, inPlace bool, backup string, quiet bool) error {
+func parseLineEndings(value string) (executor.LineEnding, error) {
+	switch strings.ToLower(value) {
+	case "keep", "":
+		return executor.LineEndingKeep, nil
+	case "lf":
+		return executor.LineEndingLF, nil
+	case "crlf":
+		return executor.LineEndingCRLF, nil
+	default:
+		return executor.LineEndingKeep, fmt.Errorf("invalid --line-endings value %q (expected keep, lf, or crlf)", value)
+	}
+}
+
+var recordSepEscapes = strings.NewReplacer(`\\`, `\`, `\n`, "\n", `\t`, "\t", `\r`, "\r", `\0`, "\x00")
+
+// parseRecordSeparator turns -z and --record-separator into executor options.
+// A value wrapped in slashes is a regular expression; anything else is taken
+// literally after expanding the usual backslash escapes.
+func parseRecordSeparator(opts options, execOpts *executor.Options) error {
+	if opts.nullData && opts.recordSep != "" {
+		return fmt.Errorf("--null-data and --record-separator cannot be used together")
+	}
+
+	if opts.nullData {
+		execOpts.RecordSeparator = "\x00"
+
+		return nil
+	}
+
+	value := opts.recordSep
+
+	if len(value) >= 2 && strings.HasPrefix(value, "/") && strings.HasSuffix(value, "/") {
+		re, err := regexp.Compile(value[1 : len(value)-1])
+		if err != nil {
+			return fmt.Errorf("invalid --record-separator regex: %w", err)
+		}
+
+		execOpts.RecordRegexp = re
+
+		return nil
+	}
+
+	execOpts.RecordSeparator = recordSepEscapes.Replace(value)
+
+	return nil
+}
+
+func runQuery(args []string, stdin io.Reader, stdout, stderr io.Writer, opts options) error {
 	query := args[0]
 
+	lineEnding, err := parseLineEndings(opts.lineEndings)
+	if err != nil {
+		return err
+	}
+
+	encoding, err := executor.ParseEncoding(opts.encoding)
+	if err != nil {
+		return fmt.Errorf("invalid --encoding value: %w", err)
+	}
+
+	if (opts.diff || opts.stat) && opts.preview == "" {
+		opts.preview = "diff"
+	}
+
+	switch opts.preview {
+	case "", "diff", "full":
+	default:
+		return fmt.Errorf("invalid --preview value %q (expected diff or full)", opts.preview)
+	}
+
+	if opts.check && (opts.inPlace || opts.confirm || opts.preview != "") {
+		return fmt.Errorf("--check cannot be combined with -i, --confirm or previews")
+	}
+
+	if opts.json && (opts.confirm || opts.check || opts.preview != "") {
+		return fmt.Errorf("--json cannot be combined with --confirm, --check or previews")
+	}
+
+	switch opts.color {
+	case "auto", "always", "never":
+	default:
+		return fmt.Errorf("invalid --color value %q (expected auto, always, or never)", opts.color)
+	}
+
+	opts.colored = colorEnabled(opts.color, stdout)
+
+	if (opts.withFilename || opts.lineNumber || opts.column) && (opts.inPlace || opts.confirm) {
+		return fmt.Errorf("-H, -n and --column cannot be combined with -i or --confirm")
+	}
+
+	if opts.confirm {
+		if opts.preview != "" {
+			return fmt.Errorf("--confirm cannot be combined with --preview, --diff or --stat")
+		}
+
+		tty, err := openTerminal()
+		if err != nil {
+			return fmt.Errorf("--confirm needs a terminal to ask on: %w", err)
+		}
+
+		defer tty.Close()
+
+		// Prompts for several files can't be interleaved.
+		opts.inPlace = true
+		opts.jobs = 1
+		opts.prompt = newPrompter(tty, stderr, colorEnabled(opts.color, stderr))
+	}
+
+	switch opts.binary {
+	case "skip", "text", "error":
+	default:
+		return fmt.Errorf("invalid --binary value %q (expected skip, text, or error)", opts.binary)
+	}
+
+	opts.backups = parseBackup(opts.backup, opts.suffix, opts.backupDir)
+
+	execOpts := executor.Options{LineEnding: lineEnding, Encoding: encoding}
+
+	if err := parseRecordSeparator(opts, &execOpts); err != nil {
+		return err
+	}
+
 	lex := lexer.New(query)
 	p := parser.New(lex)
-	ast := p.Parse()
+	command := p.Parse()
 
-	if ast == nil {
+	if command == nil {
 		return fmt.Errorf("failed to parse query: %s", query)
 	}
 
-	if ast.TokenLiteral() == "ILLEGAL" {
+	if command.TokenLiteral() == "ILLEGAL" {
 		return fmt.Errorf("unknown command in query: %s", query)
 	}
 
-	var inputs []io.Reader
-	var filenames []string
-	var closers []func() error
+	filenames, err := expandPaths(args[1:], opts)
+	if err != nil {
+		return err
+	}
 
-	if len(args) > 1 {
-		for _, filename := range args[1:] {
-			fi, err := os.Stat(filename)
-			if err != nil {
-				return fmt.Errorf("error accessing file %s: %w", filename, err)
-			}
+	if opts.inPlace && !opts.noJournal {
+		// The journal is a safety net; not having one mustn't stop the edit.
+		opts.journal, err = newJournal(query)
+		if err != nil {
+			fmt.Fprintf(stderr, "Warning: this run can't be undone: %v\n", err)
+		} else {
+			defer opts.journal.close()
+		}
+	}
 
-			// Use mmap for large files
-			if fi.Size() > mmapThreshold {
-				mmapR, err := newMmapReader(filename)
-				if err != nil {
-					return fmt.Errorf("error opening file %s: %w", filename, err)
-				}
-
-				closers = append(closers, mmapR.Close)
-				inputs = append(inputs, mmapR)
-			} else {
-				file, err := os.Open(filename)
-				if err != nil {
-					return fmt.Errorf("error opening file %s: %w", filename, err)
-				}
-
-				closers = append(closers, file.Close)
-				inputs = append(inputs, file)
-			}
+	if opts.atomicAll {
+		if !opts.inPlace {
+			return fmt.Errorf("--atomic-all requires -i")
+		}
+
+		opts.transaction = &transaction{}
+	}
 
-			filenames = append(filenames, filename)
+	if len(args) == 1 && !opts.recursive {
+		report, err := processInput(command, "stdin", stdin, nil, stdout, stderr, opts, execOpts)
+		if err != nil {
+			return err
 		}
 
-		defer func() {
-			for _, closer := range closers {
-				_ = closer()
-			}
-		}()
-	} else {
-		inputs = append(inputs, stdin)
-		filenames = append(filenames, "stdin")
-	}
-
-	for idx, input := range inputs {
-		var output io.Writer
-		var outputBuf *strings.Builder
-
-		if preview {
-			outputBuf = &strings.Builder{}
-			output = outputBuf
-		} else if inPlace && filenames[idx] != "stdin" {
-			outputBuf = &strings.Builder{}
-			output = outputBuf
-		} else {
-			output = stdout
+		if opts.stat {
+			writeDiffStat(stdout, []fileReport{report})
 		}
 
-		var inputReader io.Reader = input
+		return outcome(command, opts, []fileReport{report})
+	}
 
-		if inPlace && filenames[idx] != "stdin" {
-			content, err := io.ReadAll(input)
-			if err != nil {
-				return fmt.Errorf("error reading file %s: %w", filenames[idx], err)
-			}
+	return runFiles(command, filenames, stdout, stderr, opts, execOpts)
+}
+
+func openInput(filename string) (io.Reader, func() error, error) {
+	fi, err := os.Stat(filename)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error accessing file %s: %w", filename, err)
+	}
 
-			inputReader = strings.NewReader(string(content))
+	// Use mmap for large files
+	if fi.Size() > mmapThreshold {
+		mmapR, err := newMmapReader(filename)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error opening file %s: %w", filename, err)
 		}
 
-		err := executor.Execute(ast, inputReader, output)
+		return mmapR, mmapR.Close, nil
+	}
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error opening file %s: %w", filename, err)
+	}
+
+	return file, file.Close, nil
+}
+
+func processFile(command ast.Command, filename string, stdout, stderr io.Writer, opts options, execOpts executor.Options) (fileReport, error) {
+	var guard *fileGuard
+
+	if opts.inPlace {
+		var err error
+
+		guard, err = newFileGuard(filename, opts.verifyHash)
 		if err != nil {
-			return fmt.Errorf("execution error: %w", err)
+			return fileReport{name: filename}, err
+		}
+
+		defer guard.release()
+	}
+
+	input, closeInput, err := openInput(filename)
+	if err != nil {
+		return fileReport{name: filename}, err
+	}
+
+	defer closeInput()
+
+	return processInput(command, filename, input, guard, stdout, stderr, opts, execOpts)
+}
+
+// processInput runs command over input. For -i, guard watches the file for
+// changes by other processes; it is nil for stdin.
+func processInput(command ast.Command, filename string, input io.Reader, guard *fileGuard, stdout, stderr io.Writer, opts options, execOpts executor.Options) (fileReport, error) {
+	report := fileReport{name: filename}
+	execOpts.Format = matchFormatter(filename, opts)
+
+	// With --json, queries print their matches instead of the lines and
+	// edits print what changed instead of the result.
+	jsonMatches := opts.json && isQuery(command)
+	jsonChanges := opts.json && !jsonMatches
+	jsonEnc := newJSONEncoder(stdout)
+
+	var jsonErr error
+
+	execOpts.OnMatch = func(m executor.Match) {
+		report.matches++
+
+		if jsonMatches && jsonErr == nil {
+			jsonErr = writeJSONMatch(jsonEnc, filename, m)
 		}
+	}
+
+	input, compressed, err := decompress(input)
+	if err != nil {
+		return report, fmt.Errorf("error reading file %s: %w", filename, err)
+	}
 
-		if preview && outputBuf != nil {
-			fmt.Fprintf(stdout, "=== Preview for %s ===\n", filenames[idx])
-			fmt.Fprintln(stdout, outputBuf.String())
-			fmt.Fprintln(stdout, "=== End preview (no changes made) ===")
+	if opts.inPlace && filename != "stdin" {
+		if err := compressed.writable(); err != nil {
+			return report, fmt.Errorf("cannot edit %s in place: %w", filename, err)
 		}
+	}
 
-		if inPlace && filenames[idx] != "stdin" && outputBuf != nil {
-			if backup != "" {
-				backupName := filenames[idx] + backup
-				if err := copyFile(filenames[idx], backupName); err != nil {
-					return fmt.Errorf("error creating backup: %w", err)
-				}
+	if opts.binary != "text" {
+		br := bufio.NewReaderSize(input, binaryCheckSize)
+		head, _ := br.Peek(binaryCheckSize)
+		input = br
+
+		// NUL-separated records are text as far as we are concerned.
+		if strings.Contains(execOpts.RecordSeparator, "\x00") {
+			head = bytes.ReplaceAll(head, []byte{0}, nil)
+		}
 
-				if !quiet {
-					fmt.Fprintf(stderr, "Backup created: %s\n", backupName)
-				}
+		if executor.LooksBinary(head, execOpts.Encoding) {
+			if opts.binary == "error" {
+				return report, fmt.Errorf("%s is a binary file (use --binary=text to process it anyway)", filename)
 			}
 
-			if err := atomicWriteFile(filenames[idx], []byte(outputBuf.String())); err != nil {
-				return fmt.Errorf("error writing file %s: %w", filenames[idx], err)
+			if !opts.quiet {
+				fmt.Fprintf(stderr, "Skipping binary file: %s\n", filename)
 			}
 
-			if !quiet {
-				fmt.Fprintf(stderr, "Modified: %s\n", filenames[idx])
+			report.status = fileSkipped
+
+			return report, nil
+		}
+	}
+
+	if opts.check {
+		changed, err := executeStreaming(command, filename, input, io.Discard, execOpts)
+		if changed {
+			report.status = fileModified
+
+			if !opts.quiet {
+				fmt.Fprintln(stdout, filename)
 			}
 		}
+
+		return report, err
 	}
 
-	return nil
-}
+	inPlace := opts.inPlace && filename != "stdin"
 
-func copyFile(src, dst string) error {
-	source, err := os.Open(src)
-	if err != nil {
-		return err
+	// Plain -i streams the input into the temp file; previews and --confirm
+	// need both versions in memory to compare them.
+	if inPlace && opts.preview == "" && !opts.confirm && !jsonChanges {
+		changed, err := writeInPlace(filename, compressed, guard, stderr, opts, func(w io.Writer) (bool, error) {
+			return executeStreaming(command, filename, input, w, execOpts)
+		})
+		if changed {
+			report.status = fileModified
+		}
+
+		return report, err
 	}
 
-	defer source.Close()
+	if opts.preview == "" && !inPlace && !opts.confirm && !jsonChanges {
+		out := stdout
+		if jsonMatches {
+			out = io.Discard
+		}
 
-	destination, err := os.Create(dst)
-	if err != nil {
-		return err
+		if err := executor.ExecuteWithOptions(command, input, out, execOpts); err != nil {
+			return report, fmt.Errorf("execution error in %s: %w", filename, err)
+		}
+
+		return report, jsonErr
 	}
 
-	defer destination.Close()
+	content, err := io.ReadAll(input)
+	if err != nil {
+		return report, fmt.Errorf("error reading file %s: %w", filename, err)
+	}
 
-	buf := bufio.NewReader(source)
-	_, err = io.Copy(destination, buf)
+	// Previews, --json and --confirm compare the decoded text; the output is
+	// only encoded once it is settled.
+	decoded, enc, bom := executor.DecodeInput(bytes.NewReader(content), execOpts.Encoding)
 
-	return err
-}
+	text, err := io.ReadAll(decoded)
+	if err != nil {
+		return report, fmt.Errorf("error reading file %s: %w", filename, err)
+	}
 
-func atomicWriteFile(filename string, data []byte) error {
-	info, err := os.Stat(filename)
+	enc, bom, err = executor.OutputEncoding(command, enc, bom)
 	if err != nil {
-		return err
+		return report, fmt.Errorf("execution error in %s: %w", filename, err)
 	}
 
-	dir := filepath.Dir(filename)
+	var outputBuf strings.Builder
 
-	tempFile, err := os.CreateTemp(dir, ".ssed-*")
+	err = executor.ExecuteText(command, bytes.NewReader(text), &outputBuf, execOpts)
 	if err != nil {
-		return err
+		return report, fmt.Errorf("execution error in %s: %w", filename, err)
+	}
+
+	output := outputBuf.String()
+
+	switch opts.preview {
+	case "full":
+		fmt.Fprintf(stdout, "=== Preview for %s ===\n", filename)
+		fmt.Fprintln(stdout, output)
+		fmt.Fprintln(stdout, "=== End preview (no changes made) ===")
+	case "diff":
+		script := diff.Lines(diff.SplitLines(string(text)), diff.SplitLines(output))
+		report.inserted, report.deleted = diff.Stat(script)
+
+		if !opts.stat || opts.diff {
+			name := diffName(filename)
+			if err := diff.Unified(stdout, name, name, diff.Hunks(script, opts.context), opts.colored); err != nil {
+				return report, err
+			}
+		}
 	}
 
-	tempName := tempFile.Name()
+	if jsonChanges {
+		if err := writeJSONChanges(jsonEnc, filename, string(text), output); err != nil {
+			return report, err
+		}
+	}
 
-	defer func() {
-		if tempFile != nil {
-			tempFile.Close()
-			os.Remove(tempName)
+	if opts.confirm {
+		output, err = confirmChanges(opts.prompt, filename, string(text), output)
+		if err != nil {
+			return report, err
 		}
-	}()
+	}
 
-	if _, err := tempFile.Write(data); err != nil {
-		return err
+	if opts.confirm || inPlace {
+		if output, err = executor.EncodeText(output, enc, bom); err != nil {
+			return report, fmt.Errorf("execution error in %s: %w", filename, err)
+		}
 	}
 
-	if err := tempFile.Chmod(info.Mode()); err != nil {
+	// Standard input can't be edited in place; pass the result on.
+	if opts.confirm && !inPlace {
+		if _, err := io.WriteString(stdout, output); err != nil {
+			return report, err
+		}
+	}
+
+	if inPlace {
+		changed, err := writeInPlace(filename, compressed, guard, stderr, opts, func(w io.Writer) (bool, error) {
+			if output == string(content) {
+				return false, nil
+			}
+
+			_, err := io.WriteString(w, output)
+
+			return true, err
+		})
+		if changed {
+			report.status = fileModified
+		}
+
+		return report, err
+	}
+
+	return report, nil
+}
+
+// executeStreaming runs command from input to w and reports whether the
+// output differs from the input, comparing hashes of both so that neither
+// has to be held in memory.
+func executeStreaming(command ast.Command, filename string, input io.Reader, w io.Writer, execOpts executor.Options) (bool, error) {
+	inSum, outSum := sha256.New(), sha256.New()
+	tee := io.TeeReader(input, inSum)
+
+	if err := executor.ExecuteWithOptions(command, tee, io.MultiWriter(w, outSum), execOpts); err != nil {
+		return false, fmt.Errorf("execution error in %s: %w", filename, err)
+	}
+
+	// Hash whatever the command didn't need to read.
+	if _, err := io.Copy(io.Discard, tee); err != nil {
+		return false, fmt.Errorf("error reading file %s: %w", filename, err)
+	}
+
+	return !bytes.Equal(inSum.Sum(nil), outSum.Sum(nil)), nil
+}
+
+func copyFile(src, dst string) error {
+	source, err := os.Open(src)
+	if err != nil {
 		return err
 	}
 
-	if err := tempFile.Close(); err != nil {
+	defer source.Close()
+
+	destination, err := os.Create(dst)
+	if err != nil {
 		return err
 	}
 
-	tempFile = nil
+	defer destination.Close()
+
+	buf := bufio.NewReader(source)
+	_, err = io.Copy(destination, buf)
 
-	return os.Rename(tempName, filename)
+	return err
 }
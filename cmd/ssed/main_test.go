@@ -2,12 +2,33 @@ package main
 
 import (
 	"bytes"
+	"compress/gzip"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 )
 
+func TestMain(m *testing.M) {
+	// Keep the undo journals of -i tests out of the real state directory.
+	dir, err := os.MkdirTemp("", "ssed-state-")
+	if err != nil {
+		panic(err)
+	}
+
+	os.Setenv("SSED_STATE_DIR", dir)
+
+	code := m.Run()
+
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
 func runSsed(args ...string) (string, string, error) {
 	var stdout, stderr bytes.Buffer
 	err := Run(args, strings.NewReader(""), &stdout, &stderr)
@@ -177,7 +198,13 @@ func TestCLI_ShowWithStdin(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			stdout, _, err := runSsedWithStdin(tt.input, tt.query)
-			if err != nil {
+			if tt.expected == "" {
+				// Like grep, a show that matches nothing exits 1.
+				var status *exitStatus
+				if !errors.As(err, &status) || status.code != exitNoMatch {
+					t.Fatalf("expected exit status %d, got: %v", exitNoMatch, err)
+				}
+			} else if err != nil {
 				t.Fatalf("unexpected error: %v", err)
 			}
 
@@ -235,6 +262,37 @@ func TestCLI_InsertWithStdin(t *testing.T) {
 	}
 }
 
+func TestCLI_RemoveWithStdin(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		query    string
+		expected string
+	}{
+		{"literal", "foo bar foo\n", "remove 'foo'", " bar \n"},
+		{"regex", "a1b22\n", "remove /[0-9]+/", "ab\n"},
+		{"all digits", "order-123\n", "remove all digits", "order-\n"},
+		{"digits", "order-123\n", "remove digits", "order-\n"},
+		{"whitespace", "a b\tc\n", "remove whitespace", "abc\n"},
+		{"spaces", "a b\tc\n", "remove spaces", "ab\tc\n"},
+		{"everything after", "value # comment\n", "remove everything after '#'", "value #\n"},
+		{"text between", "f(a, b)\n", "remove text between '(' and ')'", "f()\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stdout, _, err := runSsedWithStdin(tt.input, tt.query)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if stdout != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, stdout)
+			}
+		})
+	}
+}
+
 func TestCLI_FileInput(t *testing.T) {
 	tmpDir := t.TempDir()
 	tmpFile := filepath.Join(tmpDir, "test.txt")
@@ -271,16 +329,21 @@ func TestCLI_PreviewMode(t *testing.T) {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
-	if !strings.Contains(stdout, "Preview") {
-		t.Errorf("expected preview header, got: %s", stdout)
+	if !strings.Contains(stdout, "--- "+tmpFile) {
+		t.Errorf("expected diff header, got: %s", stdout)
+	}
+
+	if !strings.Contains(stdout, "-foo bar\n+qux bar\n") {
+		t.Errorf("expected changed line in diff, got: %s", stdout)
 	}
 
-	if !strings.Contains(stdout, "qux bar") {
-		t.Errorf("expected transformed content in preview, got: %s", stdout)
+	stdout, _, err = runSsed("replace foo with qux", tmpFile, "--preview=full")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
 	}
 
-	if !strings.Contains(stdout, "no changes made") {
-		t.Errorf("expected 'no changes made' message, got: %s", stdout)
+	if !strings.Contains(stdout, "qux bar") || !strings.Contains(stdout, "no changes made") {
+		t.Errorf("expected full preview, got: %s", stdout)
 	}
 
 	afterContent, _ := os.ReadFile(tmpFile)
@@ -358,6 +421,1608 @@ func TestCLI_InPlaceWithBackup(t *testing.T) {
 	}
 }
 
+func TestCLI_InPlacePreservesLineEndings(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		args     []string
+		expected string
+	}{
+		{
+			"crlf and missing final newline kept",
+			"foo\r\nbar\r\nfoo",
+			nil,
+			"qux\r\nbar\r\nqux",
+		},
+		{
+			"mixed endings kept",
+			"a\nfoo\r\nc\n",
+			nil,
+			"a\nqux\r\nc\n",
+		},
+		{
+			"force lf",
+			"foo\r\nbar\r\n",
+			[]string{"--line-endings", "lf"},
+			"qux\nbar\n",
+		},
+		{
+			"force crlf",
+			"foo\nbar\n",
+			[]string{"--line-endings=crlf"},
+			"qux\r\nbar\r\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpFile := filepath.Join(t.TempDir(), "test.txt")
+
+			if err := os.WriteFile(tmpFile, []byte(tt.content), 0o644); err != nil {
+				t.Fatalf("failed to create temp file: %v", err)
+			}
+
+			args := append([]string{"replace foo with qux", tmpFile, "-i", "-q"}, tt.args...)
+
+			if _, _, err := runSsed(args...); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			afterContent, _ := os.ReadFile(tmpFile)
+			if string(afterContent) != tt.expected {
+				t.Errorf("expected file content %q, got %q", tt.expected, string(afterContent))
+			}
+		})
+	}
+}
+
+func TestCLI_InvalidLineEndings(t *testing.T) {
+	_, _, err := runSsedWithStdin("hello\n", "replace a with b", "--line-endings", "mac")
+	if err == nil || !strings.Contains(err.Error(), "--line-endings") {
+		t.Errorf("expected --line-endings error, got %v", err)
+	}
+}
+
+func TestCLI_RecordSeparators(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		args     []string
+		expected string
+	}{
+		{
+			"null data",
+			"a.txt\x00b.go\x00",
+			[]string{"show txt", "-z"},
+			"a.txt\x00",
+		},
+		{
+			"literal with escapes",
+			"a\tb\tc",
+			[]string{"delete b", "--record-separator", `\t`},
+			"a\tc",
+		},
+		{
+			"regex",
+			"one\n\ntwo\n\nthree\n",
+			[]string{"delete two", "--record-separator", `/\n\n/`},
+			"one\n\nthree\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stdout, _, err := runSsedWithStdin(tt.input, tt.args...)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if stdout != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, stdout)
+			}
+		})
+	}
+}
+
+func TestCLI_InvalidRecordSeparator(t *testing.T) {
+	tests := [][]string{
+		{"-z", "--record-separator", ";"},
+		{"--record-separator", "/(/"},
+	}
+
+	for _, args := range tests {
+		args = append([]string{"show a"}, args...)
+		if _, _, err := runSsedWithStdin("a\n", args...); err == nil {
+			t.Errorf("expected error for %v", args)
+		}
+	}
+}
+
+func TestCLI_InPlaceKeepsEncoding(t *testing.T) {
+	tmpFile := filepath.Join(t.TempDir(), "legacy.txt")
+	content := "\xff\xfef\x00o\x00o\x00\r\x00\n\x00"
+
+	if err := os.WriteFile(tmpFile, []byte(content), 0o644); err != nil {
+		t.Fatalf("failed to create temp file: %v", err)
+	}
+
+	if _, _, err := runSsed("replace foo with bar", tmpFile, "-i", "-q"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	afterContent, _ := os.ReadFile(tmpFile)
+	expected := "\xff\xfeb\x00a\x00r\x00\r\x00\n\x00"
+
+	if string(afterContent) != expected {
+		t.Errorf("expected file content %q, got %q", expected, string(afterContent))
+	}
+}
+
+func TestCLI_EncodingComparesText(t *testing.T) {
+	tmpFile := filepath.Join(t.TempDir(), "legacy.txt")
+	content := "\xff\xfef\x00o\x00o\x00\n\x00"
+
+	if err := os.WriteFile(tmpFile, []byte(content), 0o644); err != nil {
+		t.Fatalf("failed to create temp file: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		args     []string
+		expected string
+	}{
+		{"diff", []string{"--diff"}, "-foo\n+bar\n"},
+		{"json", []string{"--json"}, `"before":["foo"],"after":["bar"]`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stdout, _, err := runSsed(append([]string{"replace foo with bar", tmpFile}, tt.args...)...)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !strings.Contains(stdout, tt.expected) {
+				t.Errorf("expected %q in the output, got %q", tt.expected, stdout)
+			}
+		})
+	}
+
+	t.Run("confirm", func(t *testing.T) {
+		open := openTerminal
+		openTerminal = func() (io.ReadCloser, error) {
+			return io.NopCloser(strings.NewReader("y\n")), nil
+		}
+
+		defer func() { openTerminal = open }()
+
+		_, stderr, err := runSsed("replace foo with bar", tmpFile, "--confirm")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if !strings.Contains(stderr, "-foo\n+bar\n") {
+			t.Errorf("expected the decoded change to be shown, got %q", stderr)
+		}
+
+		if got, _ := os.ReadFile(tmpFile); string(got) != "\xff\xfeb\x00a\x00r\x00\n\x00" {
+			t.Errorf("expected the file to stay UTF-16, got %q", got)
+		}
+	})
+}
+
+func TestCLI_Encoding(t *testing.T) {
+	stdout, _, err := runSsedWithStdin("caf\xe9\n", "show 'café'", "--encoding", "latin1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if stdout != "caf\xe9\n" {
+		t.Errorf("expected %q, got %q", "caf\xe9\n", stdout)
+	}
+
+	if _, _, err := runSsedWithStdin("a\n", "show a", "--encoding", "ebcdic"); err == nil || !strings.Contains(err.Error(), "--encoding") {
+		t.Errorf("expected --encoding error, got %v", err)
+	}
+}
+
+func TestCLI_BinaryFiles(t *testing.T) {
+	binary := "foo\x00\x01\x02foo\n"
+
+	t.Run("skipped by default", func(t *testing.T) {
+		dir := t.TempDir()
+		binFile := filepath.Join(dir, "image.bin")
+		textFile := filepath.Join(dir, "notes.txt")
+
+		if err := os.WriteFile(binFile, []byte(binary), 0o644); err != nil {
+			t.Fatalf("failed to create temp file: %v", err)
+		}
+
+		if err := os.WriteFile(textFile, []byte("foo\n"), 0o644); err != nil {
+			t.Fatalf("failed to create temp file: %v", err)
+		}
+
+		_, stderr, err := runSsed("replace foo with bar", binFile, textFile, "-i")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if !strings.Contains(stderr, "Skipping binary file: "+binFile) {
+			t.Errorf("expected skip notice, got %q", stderr)
+		}
+
+		if content, _ := os.ReadFile(binFile); string(content) != binary {
+			t.Errorf("binary file was modified: %q", content)
+		}
+
+		if content, _ := os.ReadFile(textFile); string(content) != "bar\n" {
+			t.Errorf("expected text file to be modified, got %q", content)
+		}
+	})
+
+	t.Run("text", func(t *testing.T) {
+		stdout, _, err := runSsedWithStdin(binary, "replace foo with bar", "--binary=text")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if stdout != "bar\x00\x01\x02bar\n" {
+			t.Errorf("expected binary processed as text, got %q", stdout)
+		}
+	})
+
+	t.Run("error", func(t *testing.T) {
+		_, _, err := runSsedWithStdin(binary, "replace foo with bar", "--binary", "error")
+		if err == nil || !strings.Contains(err.Error(), "binary file") {
+			t.Errorf("expected binary file error, got %v", err)
+		}
+	})
+
+	t.Run("invalid mode", func(t *testing.T) {
+		_, _, err := runSsedWithStdin("foo\n", "show foo", "--binary", "maybe")
+		if err == nil || !strings.Contains(err.Error(), "--binary") {
+			t.Errorf("expected --binary error, got %v", err)
+		}
+	})
+}
+
+func gzipData(t *testing.T, content string, level int) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+
+	zw, err := gzip.NewWriterLevel(&buf, level)
+	if err != nil {
+		t.Fatalf("failed to create gzip writer: %v", err)
+	}
+
+	zw.Name = "app.log"
+
+	if _, err := zw.Write([]byte(content)); err != nil {
+		t.Fatalf("failed to compress: %v", err)
+	}
+
+	if err := zw.Close(); err != nil {
+		t.Fatalf("failed to compress: %v", err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestCLI_CompressedInput(t *testing.T) {
+	t.Run("gzip from stdin", func(t *testing.T) {
+		stdout, _, err := runSsedWithStdin(string(gzipData(t, "info\nerror: disk\n", gzip.DefaultCompression)), "show error")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if stdout != "error: disk\n" {
+			t.Errorf("expected %q, got %q", "error: disk\n", stdout)
+		}
+	})
+
+	t.Run("gzip in place", func(t *testing.T) {
+		tmpFile := filepath.Join(t.TempDir(), "app.log.gz")
+
+		if err := os.WriteFile(tmpFile, gzipData(t, "foo\nbar\n", gzip.BestCompression), 0o644); err != nil {
+			t.Fatalf("failed to create temp file: %v", err)
+		}
+
+		if _, _, err := runSsed("replace foo with qux", tmpFile, "-i", "-q"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		raw, _ := os.ReadFile(tmpFile)
+
+		zr, err := gzip.NewReader(bytes.NewReader(raw))
+		if err != nil {
+			t.Fatalf("file is no longer gzip: %v", err)
+		}
+
+		content, _ := io.ReadAll(zr)
+		if string(content) != "qux\nbar\n" {
+			t.Errorf("expected %q, got %q", "qux\nbar\n", string(content))
+		}
+
+		if zr.Name != "app.log" {
+			t.Errorf("expected header name to be kept, got %q", zr.Name)
+		}
+
+		if raw[8] != 2 {
+			t.Errorf("expected best compression to be kept, XFL is %d", raw[8])
+		}
+	})
+
+	// printf 'foo\nbar\n' | bzip2 -9
+	bz2, _ := hex.DecodeString("425a6839314159265359abf8618b0000024180001031009000200030c00861a52ce8185dc914e14242afe1862c")
+
+	t.Run("bzip2", func(t *testing.T) {
+		stdout, _, err := runSsedWithStdin(string(bz2), "show bar")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if stdout != "bar\n" {
+			t.Errorf("expected %q, got %q", "bar\n", stdout)
+		}
+	})
+
+	t.Run("bzip2 in place is refused", func(t *testing.T) {
+		tmpFile := filepath.Join(t.TempDir(), "app.log.bz2")
+
+		if err := os.WriteFile(tmpFile, bz2, 0o644); err != nil {
+			t.Fatalf("failed to create temp file: %v", err)
+		}
+
+		_, _, err := runSsed("replace foo with qux", tmpFile, "-i")
+		if err == nil || !strings.Contains(err.Error(), "bzip2") {
+			t.Errorf("expected bzip2 error, got %v", err)
+		}
+
+		if content, _ := os.ReadFile(tmpFile); !bytes.Equal(content, bz2) {
+			t.Error("bzip2 file was modified")
+		}
+	})
+}
+
+func TestCompileGlob(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		path     string
+		expected bool
+	}{
+		{"*.go", "main.go", true},
+		{"*.go", "pkg/executor/executor.go", true},
+		{"*.go", "main.go.orig", false},
+		{"vendor/**", "vendor/github.com/x/y.go", true},
+		{"vendor/**", "pkg/vendor/y.go", false},
+		{"**/testdata/*.txt", "pkg/parser/testdata/a.txt", true},
+		{"**/testdata/*.txt", "testdata/a.txt", true},
+		{"/build", "build", true},
+		{"/build", "sub/build", false},
+		{"file?.[ch]", "file1.c", true},
+		{"file?.[!ch]", "file1.c", false},
+	}
+
+	for _, tt := range tests {
+		g, err := compileGlob(tt.pattern)
+		if err != nil {
+			t.Fatalf("compileGlob(%q): unexpected error: %v", tt.pattern, err)
+		}
+
+		if got := g.match(tt.path); got != tt.expected {
+			t.Errorf("%q matching %q: expected %v, got %v", tt.pattern, tt.path, tt.expected, got)
+		}
+	}
+}
+
+func TestCLI_Recursive(t *testing.T) {
+	root := t.TempDir()
+
+	files := map[string]string{
+		"main.go":          "oldpkg\n",
+		"pkg/lib.go":       "oldpkg\n",
+		"pkg/notes.txt":    "oldpkg\n",
+		"vendor/dep/x.go":  "oldpkg\n",
+		".git/config":      "oldpkg\n",
+		"build/gen.go":     "oldpkg\n",
+		"logs/keep.go":     "oldpkg\n",
+		"logs/skip.go":     "oldpkg\n",
+		".gitignore":       "build/\nlogs/*.go\n!logs/keep.go\n",
+		"pkg/sub/.ignore":  "*.go\n",
+		"pkg/sub/other.go": "oldpkg\n",
+	}
+
+	for name, content := range files {
+		full := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(full), 0o755); err != nil {
+			t.Fatalf("failed to create dir: %v", err)
+		}
+
+		if err := os.WriteFile(full, []byte(content), 0o644); err != nil {
+			t.Fatalf("failed to create file: %v", err)
+		}
+	}
+
+	// A symlink back to the root must not loop forever.
+	if err := os.Symlink("..", filepath.Join(root, "pkg", "loop")); err != nil {
+		t.Fatalf("failed to create symlink: %v", err)
+	}
+
+	_, _, err := runSsed("replace oldpkg with newpkg", root, "-r", "-i", "-q", "--include", "*.go", "--exclude", "vendor/**")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := map[string]string{
+		"main.go":          "newpkg\n",
+		"pkg/lib.go":       "newpkg\n",
+		"pkg/notes.txt":    "oldpkg\n",
+		"vendor/dep/x.go":  "oldpkg\n",
+		".git/config":      "oldpkg\n",
+		"build/gen.go":     "oldpkg\n",
+		"logs/keep.go":     "newpkg\n",
+		"logs/skip.go":     "oldpkg\n",
+		"pkg/sub/other.go": "oldpkg\n",
+	}
+
+	for name, want := range expected {
+		content, _ := os.ReadFile(filepath.Join(root, name))
+		if string(content) != want {
+			t.Errorf("%s: expected %q, got %q", name, want, string(content))
+		}
+	}
+}
+
+func TestCLI_RecursiveAbsoluteSymlink(t *testing.T) {
+	dir := t.TempDir()
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("failed to get working directory: %v", err)
+	}
+
+	if err := os.Chdir(dir); err != nil {
+		t.Fatalf("failed to change directory: %v", err)
+	}
+
+	defer os.Chdir(wd)
+
+	if err := os.Mkdir("d", 0o755); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+
+	if err := os.WriteFile(filepath.Join("d", "f"), []byte("foo\n"), 0o644); err != nil {
+		t.Fatalf("failed to create temp file: %v", err)
+	}
+
+	// The relative root and the absolute link target are the same directory.
+	if err := os.Symlink(filepath.Join(dir, "d"), filepath.Join("d", "loop")); err != nil {
+		t.Fatalf("failed to create symlink: %v", err)
+	}
+
+	_, stderr, err := runSsed("replace foo with foofoo", "d", "-r", "-i")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if strings.Count(stderr, "Modified:") != 1 {
+		t.Errorf("expected one modified file, got:\n%s", stderr)
+	}
+
+	if got, _ := os.ReadFile(filepath.Join("d", "f")); string(got) != "foofoo\n" {
+		t.Errorf("expected d/f to be edited once, got %q", got)
+	}
+}
+
+func TestCLI_RecursiveParentIgnores(t *testing.T) {
+	root := t.TempDir()
+
+	files := map[string]string{
+		".git/info/exclude": "*.log\n",
+		".gitignore":        "*.txt\n",
+		"src/.gitignore":    "!keep.txt\n",
+		"src/a.go":          "old\n",
+		"src/c.txt":         "old\n",
+		"src/keep.txt":      "old\n",
+		"src/debug.log":     "old\n",
+	}
+
+	for name, content := range files {
+		full := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(full), 0o755); err != nil {
+			t.Fatalf("failed to create dir: %v", err)
+		}
+
+		if err := os.WriteFile(full, []byte(content), 0o644); err != nil {
+			t.Fatalf("failed to create file: %v", err)
+		}
+	}
+
+	stdout, _, err := runSsed("show old", filepath.Join(root, "src"), "-r", "-H")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	src := filepath.Join(root, "src")
+	expected := filepath.Join(src, "a.go") + ":old\n" + filepath.Join(src, "keep.txt") + ":old\n"
+
+	if stdout != expected {
+		t.Errorf("expected %q, got %q", expected, stdout)
+	}
+
+	stdout, _, err = runSsed("count old", filepath.Join(root, "src"), "-r", "--no-ignore")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// src/.gitignore, a.go, c.txt, debug.log and keep.txt.
+	if stdout != "0\n1\n1\n1\n1\n" {
+		t.Errorf("expected --no-ignore to process every file, got %q", stdout)
+	}
+}
+
+func TestCLI_DeleteEmptyPattern(t *testing.T) {
+	for _, query := range []string{"delete ''", "delete lines ''", "delete lines '' then trim"} {
+		stdout, _, err := runSsedWithStdin("a\n\n  \nb\n", query)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", query, err)
+		}
+
+		expected := "a\n  \nb\n"
+		if strings.HasSuffix(query, "trim") {
+			expected = "a\n\nb\n"
+		}
+
+		if stdout != expected {
+			t.Errorf("%s: expected %q, got %q", query, expected, stdout)
+		}
+	}
+}
+
+func TestCLI_DirectoryWithoutRecursive(t *testing.T) {
+	_, _, err := runSsed("show x", t.TempDir())
+	if err == nil || !strings.Contains(err.Error(), "-r") {
+		t.Errorf("expected directory error mentioning -r, got %v", err)
+	}
+}
+
+func TestCLI_ParallelKeepsOrder(t *testing.T) {
+	dir := t.TempDir()
+
+	var args []string
+
+	var expected strings.Builder
+
+	for i := 0; i < 50; i++ {
+		name := filepath.Join(dir, fmt.Sprintf("f%02d.txt", i))
+		content := strings.Repeat(fmt.Sprintf("item %d\n", i), i*100)
+
+		if err := os.WriteFile(name, []byte(content), 0o644); err != nil {
+			t.Fatalf("failed to create temp file: %v", err)
+		}
+
+		args = append(args, name)
+
+		expected.WriteString(content)
+	}
+
+	stdout, _, err := runSsed(append([]string{"show item", "-j", "8"}, args...)...)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if stdout != expected.String() {
+		t.Error("parallel output is not in argument order")
+	}
+}
+
+func TestCLI_ParallelCollectsErrors(t *testing.T) {
+	dir := t.TempDir()
+	bz2, _ := hex.DecodeString("425a6839314159265359abf8618b0000024180001031009000200030c00861a52ce8185dc914e14242afe1862c")
+
+	files := []string{filepath.Join(dir, "a.txt"), filepath.Join(dir, "b.bz2"), filepath.Join(dir, "c.txt")}
+
+	for _, name := range files {
+		content := []byte("foo\n")
+		if strings.HasSuffix(name, ".bz2") {
+			content = bz2
+		}
+
+		if err := os.WriteFile(name, content, 0o644); err != nil {
+			t.Fatalf("failed to create temp file: %v", err)
+		}
+	}
+
+	for _, jobs := range []string{"1", "4"} {
+		t.Run("jobs "+jobs, func(t *testing.T) {
+			for _, name := range []string{files[0], files[2]} {
+				_ = os.WriteFile(name, []byte("foo\n"), 0o644)
+			}
+
+			_, stderr, err := runSsed(append([]string{"replace foo with bar", "-i", "-j", jobs}, files...)...)
+			if err == nil || !strings.Contains(err.Error(), "1 of 3 files failed") {
+				t.Errorf("expected summary error, got %v", err)
+			}
+
+			if !strings.Contains(stderr, "Processed 3 files: 2 modified, 0 skipped, 1 failed") {
+				t.Errorf("expected summary, got %q", stderr)
+			}
+
+			for _, name := range []string{files[0], files[2]} {
+				if content, _ := os.ReadFile(name); string(content) != "bar\n" {
+					t.Errorf("%s: expected %q, got %q", name, "bar\n", content)
+				}
+			}
+		})
+	}
+}
+
+func TestCLI_InPlaceUnchangedFileNotRewritten(t *testing.T) {
+	tmpFile := filepath.Join(t.TempDir(), "test.txt")
+
+	if err := os.WriteFile(tmpFile, []byte("foo\n"), 0o644); err != nil {
+		t.Fatalf("failed to create temp file: %v", err)
+	}
+
+	_, stderr, err := runSsed("replace nothing with bar", tmpFile, "-i", "--backup", ".bak")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if strings.Contains(stderr, "Modified") {
+		t.Errorf("unexpected 'Modified' message: %q", stderr)
+	}
+
+	if _, err := os.Stat(tmpFile + ".bak"); !os.IsNotExist(err) {
+		t.Error("expected no backup for an unchanged file")
+	}
+}
+
+func TestCLI_Diff(t *testing.T) {
+	dir := t.TempDir()
+	first := filepath.Join(dir, "a.txt")
+	second := filepath.Join(dir, "b.txt")
+
+	if err := os.WriteFile(first, []byte("1\n2\nfoo\n4\n5\n6\n7\n"), 0o644); err != nil {
+		t.Fatalf("failed to create temp file: %v", err)
+	}
+
+	if err := os.WriteFile(second, []byte("foo\nfoo\n"), 0o644); err != nil {
+		t.Fatalf("failed to create temp file: %v", err)
+	}
+
+	t.Run("unified", func(t *testing.T) {
+		stdout, _, err := runSsed("replace foo with bar", first, "--diff", "-U", "1")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		expected := "--- " + first + "\n+++ " + first + "\n@@ -2,3 +2,3 @@\n 2\n-foo\n+bar\n 4\n"
+		if stdout != expected {
+			t.Errorf("expected:\n%s\ngot:\n%s", expected, stdout)
+		}
+	})
+
+	t.Run("stat", func(t *testing.T) {
+		stdout, _, err := runSsed("replace foo with bar", first, second, "--stat")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if strings.Contains(stdout, "@@") {
+			t.Errorf("--stat alone should not print the diff, got: %s", stdout)
+		}
+
+		if !strings.Contains(stdout, first+" | 2 +-") || !strings.Contains(stdout, second+" | 4 ++--") {
+			t.Errorf("expected per-file stats, got: %s", stdout)
+		}
+
+		if !strings.Contains(stdout, "2 files changed, 3 insertions(+), 3 deletions(-)") {
+			t.Errorf("expected stat summary, got: %s", stdout)
+		}
+	})
+
+	t.Run("relative names", func(t *testing.T) {
+		if got := diffName("pkg/a.go"); got != "./pkg/a.go" {
+			t.Errorf("expected ./pkg/a.go, got %s", got)
+		}
+	})
+}
+
+func TestCLI_Confirm(t *testing.T) {
+	dir := t.TempDir()
+	first := filepath.Join(dir, "a.txt")
+	second := filepath.Join(dir, "b.txt")
+
+	write := func() {
+		if err := os.WriteFile(first, []byte("foo 1\nkeep\nfoo 2\nkeep\nfoo 3\n"), 0o644); err != nil {
+			t.Fatalf("failed to create temp file: %v", err)
+		}
+
+		if err := os.WriteFile(second, []byte("foo 4\n"), 0o644); err != nil {
+			t.Fatalf("failed to create temp file: %v", err)
+		}
+	}
+
+	// The answers come from the terminal.
+	answer := func(t *testing.T, answers string, err error) {
+		t.Helper()
+
+		open := openTerminal
+		openTerminal = func() (io.ReadCloser, error) {
+			return io.NopCloser(strings.NewReader(answers)), err
+		}
+
+		t.Cleanup(func() { openTerminal = open })
+	}
+
+	tests := []struct {
+		name    string
+		answers string
+		first   string
+		second  string
+	}{
+		{"yes and no", "y\nn\ny\nn\n", "bar 1\nkeep\nfoo 2\nkeep\nbar 3\n", "foo 4\n"},
+		{"all", "n\na\n", "foo 1\nkeep\nbar 2\nkeep\nbar 3\n", "bar 4\n"},
+		{"quit", "y\nq\n", "bar 1\nkeep\nfoo 2\nkeep\nfoo 3\n", "foo 4\n"},
+		{"end of input", "?\ny\n", "bar 1\nkeep\nfoo 2\nkeep\nfoo 3\n", "foo 4\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			write()
+			answer(t, tt.answers, nil)
+
+			_, stderr, err := runSsed("replace foo with bar", first, second, "--confirm")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !strings.Contains(stderr, first+":1\n-foo 1\n+bar 1\nApply this change? [y,n,a,q]") {
+				t.Errorf("expected a prompt for the first change, got: %s", stderr)
+			}
+
+			if got, _ := os.ReadFile(first); string(got) != tt.first {
+				t.Errorf("expected %q in %s, got %q", tt.first, first, got)
+			}
+
+			if got, _ := os.ReadFile(second); string(got) != tt.second {
+				t.Errorf("expected %q in %s, got %q", tt.second, second, got)
+			}
+		})
+	}
+
+	t.Run("stdin", func(t *testing.T) {
+		answer(t, "n\ny\n", nil)
+
+		stdout, _, err := runSsedWithStdin("foo 1\nkeep\nfoo 2\n", "replace foo with bar", "--confirm")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if stdout != "foo 1\nkeep\nbar 2\n" {
+			t.Errorf("expected the accepted changes on stdout, got %q", stdout)
+		}
+	})
+
+	t.Run("no terminal", func(t *testing.T) {
+		answer(t, "", errors.New("no such device"))
+
+		write()
+
+		_, _, err := runSsed("replace foo with bar", first, "--confirm")
+		if err == nil || !strings.Contains(err.Error(), "needs a terminal") {
+			t.Errorf("expected an error without a terminal, got: %v", err)
+		}
+
+		if got, _ := os.ReadFile(first); !strings.HasPrefix(string(got), "foo 1") {
+			t.Errorf("expected %s to be left alone, got %q", first, got)
+		}
+	})
+}
+
+func TestCLI_Undo(t *testing.T) {
+	t.Setenv("SSED_STATE_DIR", t.TempDir())
+
+	dir := t.TempDir()
+	first := filepath.Join(dir, "a.txt")
+	second := filepath.Join(dir, "b.txt")
+
+	for _, name := range []string{first, second} {
+		if err := os.WriteFile(name, []byte("foo\n"), 0o644); err != nil {
+			t.Fatalf("failed to create temp file: %v", err)
+		}
+	}
+
+	if _, _, err := runSsed("replace foo with bar", first, second, "-i", "-q"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, _, err := runSsed("replace bar with baz", first, "-i", "-q"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	stdout, _, err := runSsed("history")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(stdout), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], `1 file  "replace bar with baz"`) ||
+		!strings.Contains(lines[1], `2 files  "replace foo with bar"`) {
+		t.Fatalf("unexpected history:\n%s", stdout)
+	}
+
+	firstRun := strings.Fields(lines[1])[0]
+
+	if _, _, err := runSsed("undo", firstRun); err == nil || !strings.Contains(err.Error(), "has changed since") {
+		t.Errorf("expected undo to refuse a file changed since, got: %v", err)
+	}
+
+	if got, _ := os.ReadFile(second); string(got) != "bar\n" {
+		t.Errorf("a refused undo must not restore anything, got %q", got)
+	}
+
+	if _, _, err := runSsed("undo"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got, _ := os.ReadFile(first); string(got) != "bar\n" {
+		t.Errorf("expected the latest run to be undone, got %q", got)
+	}
+
+	_, stderr, err := runSsed("undo")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !strings.Contains(stderr, "Restored: "+second) {
+		t.Errorf("expected restore messages, got: %s", stderr)
+	}
+
+	for _, name := range []string{first, second} {
+		if got, _ := os.ReadFile(name); string(got) != "foo\n" {
+			t.Errorf("expected %s to be restored, got %q", name, got)
+		}
+	}
+
+	if _, _, err := runSsed("undo"); err == nil {
+		t.Error("expected an error with nothing left to undo")
+	}
+
+	stdout, _, _ = runSsed("history")
+	if strings.Count(stdout, "(undone)") != 2 {
+		t.Errorf("expected both runs marked undone, got:\n%s", stdout)
+	}
+}
+
+func TestCLI_JournalUnavailable(t *testing.T) {
+	dir := t.TempDir()
+	tmpFile := filepath.Join(dir, "test.txt")
+	notADir := filepath.Join(dir, "state")
+
+	if err := os.WriteFile(notADir, nil, 0o644); err != nil {
+		t.Fatalf("failed to create temp file: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		env     map[string]string
+		warning string
+	}{
+		{"no state directory", map[string]string{"SSED_STATE_DIR": "", "XDG_STATE_HOME": "", "HOME": ""}, "can't be undone"},
+		{"unwritable state directory", map[string]string{"SSED_STATE_DIR": notADir}, "error recording"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+
+			if _, err := stateDir(); err == nil && tt.env["SSED_STATE_DIR"] == "" {
+				t.Skip("the home directory doesn't come from $HOME here")
+			}
+
+			if err := os.WriteFile(tmpFile, []byte("foo\n"), 0o644); err != nil {
+				t.Fatalf("failed to create temp file: %v", err)
+			}
+
+			_, stderr, err := runSsed("replace foo with bar", tmpFile, "-i", "-q")
+			if err != nil {
+				t.Fatalf("a missing journal must not stop the edit: %v", err)
+			}
+
+			if !strings.Contains(stderr, "Warning: ") || !strings.Contains(stderr, tt.warning) {
+				t.Errorf("expected a warning, got: %s", stderr)
+			}
+
+			if got, _ := os.ReadFile(tmpFile); string(got) != "bar\n" {
+				t.Errorf("expected the file to be edited, got %q", got)
+			}
+		})
+	}
+}
+
+func TestCLI_JournalLinksOriginals(t *testing.T) {
+	state := t.TempDir()
+	t.Setenv("SSED_STATE_DIR", state)
+
+	dir := t.TempDir()
+	renamed := filepath.Join(dir, "a.txt")
+	linked := filepath.Join(dir, "b.txt")
+
+	for _, name := range []string{renamed, linked} {
+		if err := os.WriteFile(name, []byte("foo\n"), 0o644); err != nil {
+			t.Fatalf("failed to create temp file: %v", err)
+		}
+	}
+
+	if err := os.Link(linked, filepath.Join(dir, "b-link.txt")); err != nil {
+		t.Skipf("hard links not supported: %v", err)
+	}
+
+	before, err := os.Stat(renamed)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, _, err := runSsed("replace foo with bar", renamed, linked, "-i", "-q"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	origs, _ := filepath.Glob(filepath.Join(state, "runs", "*", "*.orig"))
+	if len(origs) != 2 {
+		t.Fatalf("expected two originals in the journal, got %v", origs)
+	}
+
+	// A file replaced by rename keeps its old inode in the journal.
+	if info, err := os.Stat(origs[0]); err != nil || !os.SameFile(before, info) {
+		t.Errorf("expected the original of %s to be linked into the journal (%v)", renamed, err)
+	}
+
+	// A hard-linked file is overwritten in place, so its original is copied.
+	if got, _ := os.ReadFile(origs[1]); string(got) != "foo\n" {
+		t.Errorf("expected a copy of the original of %s, got %q", linked, got)
+	}
+
+	if _, _, err := runSsed("undo"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, name := range []string{renamed, linked} {
+		if got, _ := os.ReadFile(name); string(got) != "foo\n" {
+			t.Errorf("expected %s to be restored, got %q", name, got)
+		}
+	}
+}
+
+func TestCLI_AtomicAll(t *testing.T) {
+	dir := t.TempDir()
+	names := []string{filepath.Join(dir, "a.txt"), filepath.Join(dir, "b.txt"), filepath.Join(dir, "c.txt")}
+
+	for _, name := range names {
+		if err := os.WriteFile(name, []byte("foo\n"), 0o644); err != nil {
+			t.Fatalf("failed to create temp file: %v", err)
+		}
+	}
+
+	broken := names[1]
+	if err := os.WriteFile(broken, []byte{0x28, 0xb5, 0x2f, 0xfd, 0}, 0o644); err != nil {
+		t.Fatalf("failed to create temp file: %v", err)
+	}
+
+	for _, jobs := range []string{"1", "3"} {
+		_, _, err := runSsed("replace foo with bar", names[0], names[1], names[2], "-i", "--atomic-all", "-j", jobs)
+		if err == nil || !strings.Contains(err.Error(), "aborted because "+broken+" failed") {
+			t.Fatalf("-j %s: expected the abort to name %s, got: %v", jobs, broken, err)
+		}
+
+		for _, name := range []string{names[0], names[2]} {
+			if got, _ := os.ReadFile(name); string(got) != "foo\n" {
+				t.Errorf("-j %s: expected %s to be left alone, got %q", jobs, name, got)
+			}
+		}
+
+		if leftovers, _ := filepath.Glob(filepath.Join(dir, ".ssed-*")); len(leftovers) > 0 {
+			t.Errorf("-j %s: temp files left behind: %v", jobs, leftovers)
+		}
+	}
+
+	_, stderr, err := runSsed("replace foo with bar", names[0], names[2], "-i", "--atomic-all", "--backup", ".bak")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, name := range []string{names[0], names[2]} {
+		if got, _ := os.ReadFile(name); string(got) != "bar\n" {
+			t.Errorf("expected %s to be changed, got %q", name, got)
+		}
+
+		if got, _ := os.ReadFile(name + ".bak"); string(got) != "foo\n" {
+			t.Errorf("expected a backup of %s, got %q", name, got)
+		}
+	}
+
+	if !strings.Contains(stderr, "Processed 2 files: 2 modified") {
+		t.Errorf("expected a summary, got: %s", stderr)
+	}
+
+	if leftovers, _ := filepath.Glob(filepath.Join(dir, ".ssed-*")); len(leftovers) > 0 {
+		t.Errorf("temp files left behind: %v", leftovers)
+	}
+
+	if _, _, err := runSsed("replace foo with bar", names[0], "--atomic-all"); err == nil {
+		t.Error("expected --atomic-all without -i to fail")
+	}
+}
+
+func TestCLI_InPlaceKeepsLinks(t *testing.T) {
+	for _, atomicAll := range []bool{false, true} {
+		dir := t.TempDir()
+		target := filepath.Join(dir, "target.txt")
+		symlink := filepath.Join(dir, "symlink.txt")
+		hardlink := filepath.Join(dir, "hardlink.txt")
+		other := filepath.Join(dir, "other.txt")
+
+		if err := os.WriteFile(target, []byte("foo\n"), 0o640); err != nil {
+			t.Fatalf("failed to create temp file: %v", err)
+		}
+
+		if err := os.WriteFile(other, []byte("foo\n"), 0o644); err != nil {
+			t.Fatalf("failed to create temp file: %v", err)
+		}
+
+		if err := os.Symlink("target.txt", symlink); err != nil {
+			t.Skipf("symlinks not supported: %v", err)
+		}
+
+		if err := os.Link(other, hardlink); err != nil {
+			t.Skipf("hard links not supported: %v", err)
+		}
+
+		args := []string{"replace foo with bar", symlink, hardlink, "-i", "-q"}
+		if atomicAll {
+			args = append(args, "--atomic-all")
+		}
+
+		if _, _, err := runSsed(args...); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if fi, err := os.Lstat(symlink); err != nil || fi.Mode()&os.ModeSymlink == 0 {
+			t.Errorf("atomic-all=%v: expected %s to stay a symlink", atomicAll, symlink)
+		}
+
+		if fi, _ := os.Stat(target); fi.Mode().Perm() != 0o640 {
+			t.Errorf("atomic-all=%v: expected mode 0640, got %v", atomicAll, fi.Mode().Perm())
+		}
+
+		for _, name := range []string{target, other} {
+			if got, _ := os.ReadFile(name); string(got) != "bar\n" {
+				t.Errorf("atomic-all=%v: expected %s to be edited, got %q", atomicAll, name, got)
+			}
+		}
+
+		a, _ := os.Stat(other)
+		b, _ := os.Stat(hardlink)
+
+		if !os.SameFile(a, b) {
+			t.Errorf("atomic-all=%v: expected %s and %s to stay hard linked", atomicAll, other, hardlink)
+		}
+	}
+}
+
+func TestCLI_PreserveMtime(t *testing.T) {
+	tmpFile := filepath.Join(t.TempDir(), "test.txt")
+
+	if err := os.WriteFile(tmpFile, []byte("foo\n"), 0o644); err != nil {
+		t.Fatalf("failed to create temp file: %v", err)
+	}
+
+	old := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := os.Chtimes(tmpFile, old, old); err != nil {
+		t.Fatalf("failed to set mtime: %v", err)
+	}
+
+	if _, _, err := runSsed("replace foo with bar", tmpFile, "-i", "-q", "--preserve-mtime"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	fi, _ := os.Stat(tmpFile)
+	if !fi.ModTime().Equal(old) {
+		t.Errorf("expected mtime %v, got %v", old, fi.ModTime())
+	}
+
+	if _, _, err := runSsed("replace bar with baz", tmpFile, "-i", "-q"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if fi, _ := os.Stat(tmpFile); fi.ModTime().Equal(old) {
+		t.Error("expected the mtime to change without --preserve-mtime")
+	}
+}
+
+func TestCLI_InPlaceFailureLeavesFile(t *testing.T) {
+	dir := t.TempDir()
+	tmpFile := filepath.Join(dir, "test.txt")
+	content := strings.Repeat("plain line\n", 10000) + "π\n"
+
+	if err := os.WriteFile(tmpFile, []byte(content), 0o644); err != nil {
+		t.Fatalf("failed to create temp file: %v", err)
+	}
+
+	_, _, err := runSsed("convert encoding to latin1", tmpFile, "-i", "-q")
+	if err == nil || !strings.Contains(err.Error(), "cannot encode") {
+		t.Fatalf("expected an encoding error, got: %v", err)
+	}
+
+	if got, _ := os.ReadFile(tmpFile); string(got) != content {
+		t.Error("a failed edit must leave the file as it was")
+	}
+
+	if leftovers, _ := filepath.Glob(filepath.Join(dir, ".ssed-*")); len(leftovers) > 0 {
+		t.Errorf("temp files left behind: %v", leftovers)
+	}
+}
+
+func TestFileGuard(t *testing.T) {
+	tmpFile := filepath.Join(t.TempDir(), "test.txt")
+
+	write := func(content string, mtime time.Time) {
+		t.Helper()
+
+		if err := os.WriteFile(tmpFile, []byte(content), 0o644); err != nil {
+			t.Fatalf("failed to write temp file: %v", err)
+		}
+
+		if err := os.Chtimes(tmpFile, mtime, mtime); err != nil {
+			t.Fatalf("failed to set mtime: %v", err)
+		}
+	}
+
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	for _, withHash := range []bool{false, true} {
+		write("foo\n", mtime)
+
+		g, err := newFileGuard(tmpFile, withHash)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if err := g.verify(); err != nil {
+			t.Errorf("hash=%v: unchanged file reported as changed: %v", withHash, err)
+		}
+
+		write("foo\nappended by someone else\n", mtime)
+
+		if err := g.verify(); err == nil || !strings.Contains(err.Error(), "changed by another process") {
+			t.Errorf("hash=%v: expected a change in size to be noticed, got: %v", withHash, err)
+		}
+
+		// Same size and mtime: only the hash can tell.
+		write("bar\n", mtime)
+
+		if err := g.verify(); (err != nil) != withHash {
+			t.Errorf("hash=%v: unexpected result for a same-size rewrite: %v", withHash, err)
+		}
+
+		g.release()
+	}
+}
+
+func TestTransactionRestoresOnFailure(t *testing.T) {
+	dir := t.TempDir()
+	renamed := filepath.Join(dir, "a.txt")
+	linked := filepath.Join(dir, "b.txt")
+
+	for _, name := range []string{renamed, linked} {
+		if err := os.WriteFile(name, []byte("old\n"), 0o644); err != nil {
+			t.Fatalf("failed to create temp file: %v", err)
+		}
+	}
+
+	if err := os.Link(linked, filepath.Join(dir, "b-link.txt")); err != nil {
+		t.Skipf("hard links not supported: %v", err)
+	}
+
+	var tx transaction
+
+	for _, name := range []string{renamed, linked} {
+		p, err := newPendingWrite(name)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if _, err := p.Write([]byte("new\n")); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		p.close()
+		tx.stage(p)
+	}
+
+	// Reading the new content of the hard-linked file fails only after its
+	// target has been truncated.
+	broken := tx.files[1].temp
+	os.Remove(broken)
+
+	if err := os.Mkdir(broken, 0o700); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+
+	defer os.Remove(broken)
+
+	err := tx.commit(options{quiet: true, backups: parseBackup(".bak", "~", "")}, io.Discard)
+	if err == nil || !strings.Contains(err.Error(), "no files were changed") {
+		t.Fatalf("expected the commit to fail and roll back, got: %v", err)
+	}
+
+	for _, name := range []string{renamed, linked} {
+		if got, _ := os.ReadFile(name); string(got) != "old\n" {
+			t.Errorf("expected %s to be restored, got %q", name, got)
+		}
+
+		// Backups are made before any file is replaced.
+		if got, _ := os.ReadFile(name + ".bak"); string(got) != "old\n" {
+			t.Errorf("expected a backup of %s, got %q", name, got)
+		}
+	}
+
+	if leftovers, _ := filepath.Glob(filepath.Join(dir, ".ssed-orig-*")); len(leftovers) > 0 {
+		t.Errorf("copies of the originals left behind: %v", leftovers)
+	}
+}
+
+func TestCLI_BackupModes(t *testing.T) {
+	dir := t.TempDir()
+	tmpFile := filepath.Join(dir, "test.txt")
+
+	edit := func(content string, args ...string) {
+		t.Helper()
+
+		if err := os.WriteFile(tmpFile, []byte(content), 0o644); err != nil {
+			t.Fatalf("failed to write temp file: %v", err)
+		}
+
+		args = append([]string{"replace foo with bar", tmpFile, "-i", "-q"}, args...)
+		if _, _, err := runSsed(args...); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	expectBackup := func(name, content string) {
+		t.Helper()
+
+		if got, err := os.ReadFile(name); err != nil || string(got) != content {
+			t.Errorf("expected backup %s with %q, got %q (%v)", name, content, got, err)
+		}
+	}
+
+	edit("foo 1\n", "--backup=simple")
+	expectBackup(tmpFile+"~", "foo 1\n")
+
+	edit("foo 2\n", "--backup=simple", "--suffix", ".orig")
+	expectBackup(tmpFile+".orig", "foo 2\n")
+
+	// Without numbered backups yet, existing behaves like simple.
+	edit("foo 3\n", "--backup=existing")
+	expectBackup(tmpFile+"~", "foo 3\n")
+
+	edit("foo 4\n", "--backup=numbered")
+	edit("foo 5\n", "--backup=numbered")
+	edit("foo 6\n", "--backup=existing")
+	expectBackup(tmpFile+".~1~", "foo 4\n")
+	expectBackup(tmpFile+".~2~", "foo 5\n")
+	expectBackup(tmpFile+".~3~", "foo 6\n")
+	expectBackup(tmpFile+"~", "foo 3\n")
+
+	t.Run("backup dir", func(t *testing.T) {
+		wd, err := os.Getwd()
+		if err != nil {
+			t.Fatalf("failed to get working directory: %v", err)
+		}
+
+		if err := os.Chdir(dir); err != nil {
+			t.Fatalf("failed to change directory: %v", err)
+		}
+
+		defer os.Chdir(wd)
+
+		if err := os.MkdirAll(filepath.Join("src", "pkg"), 0o755); err != nil {
+			t.Fatalf("failed to create directory: %v", err)
+		}
+
+		name := filepath.Join("src", "pkg", "a.txt")
+		if err := os.WriteFile(name, []byte("foo\n"), 0o644); err != nil {
+			t.Fatalf("failed to create temp file: %v", err)
+		}
+
+		if _, _, err := runSsed("replace foo with bar", name, "-i", "-q", "--backup-dir", "backups"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		expectBackup(filepath.Join("backups", "src", "pkg", "a.txt"), "foo\n")
+
+		if got, _ := os.ReadFile(name); string(got) != "bar\n" {
+			t.Errorf("expected the file to be edited, got %q", got)
+		}
+	})
+}
+
+func TestCLI_ExitCodes(t *testing.T) {
+	dir := t.TempDir()
+	clean := filepath.Join(dir, "clean.txt")
+	dirty := filepath.Join(dir, "dirty.txt")
+
+	if err := os.WriteFile(clean, []byte("ok\n"), 0o644); err != nil {
+		t.Fatalf("failed to create temp file: %v", err)
+	}
+
+	if err := os.WriteFile(dirty, []byte("trailing  \n"), 0o644); err != nil {
+		t.Fatalf("failed to create temp file: %v", err)
+	}
+
+	exitCode := func(err error) int {
+		var status *exitStatus
+		if errors.As(err, &status) {
+			return status.code
+		}
+
+		if err != nil {
+			return exitError
+		}
+
+		return 0
+	}
+
+	tests := []struct {
+		name   string
+		args   []string
+		code   int
+		stdout string
+	}{
+		{"show matched in one file", []string{"show trailing", clean, dirty}, 0, "trailing  \n"},
+		{"show matched nothing", []string{"show missing", clean, dirty}, exitNoMatch, ""},
+		{"count of zero", []string{"count missing", clean}, exitNoMatch, "0\n"},
+		{"count", []string{"count ok", clean}, 0, "1\n"},
+		{"edits always succeed", []string{"replace missing with x", clean}, 0, "ok\n"},
+		{"check finds changes", []string{"trim", clean, dirty, "--check"}, exitNoMatch, dirty + "\n"},
+		{"check is clean", []string{"trim", clean, "--check"}, 0, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stdout, _, err := runSsed(tt.args...)
+			if code := exitCode(err); code != tt.code {
+				t.Errorf("expected exit code %d, got %d (%v)", tt.code, code, err)
+			}
+
+			if stdout != tt.stdout {
+				t.Errorf("expected stdout %q, got %q", tt.stdout, stdout)
+			}
+		})
+	}
+
+	if got, _ := os.ReadFile(dirty); string(got) != "trailing  \n" {
+		t.Errorf("--check must not modify files, got %q", got)
+	}
+
+	if _, _, err := runSsed("trim", dirty, "--check", "-i"); exitCode(err) != exitError {
+		t.Errorf("expected --check with -i to be rejected, got: %v", err)
+	}
+}
+
+func TestCLI_Prefixes(t *testing.T) {
+	dir := t.TempDir()
+	first := filepath.Join(dir, "first.txt")
+	second := filepath.Join(dir, "second.txt")
+
+	if err := os.WriteFile(first, []byte("a foo\nbar\nx foo\n"), 0o644); err != nil {
+		t.Fatalf("failed to create temp file: %v", err)
+	}
+
+	if err := os.WriteFile(second, []byte("foo\n"), 0o644); err != nil {
+		t.Fatalf("failed to create temp file: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		args     []string
+		expected string
+	}{
+		{"file names", []string{"show foo", first, second, "-H"}, first + ":a foo\n" + first + ":x foo\n" + second + ":foo\n"},
+		{"line numbers", []string{"show foo", first, "-n"}, "1:a foo\n3:x foo\n"},
+		{"original line numbers", []string{"delete bar then show last 1 line", first, "-n"}, "3:x foo\n"},
+		{"line numbers after insert", []string{"insert z after bar then show foo", first, "-n"}, "1:a foo\n3:x foo\n"},
+		{"quickfix", []string{"show foo", first, "-H", "--column"}, first + ":1:3:a foo\n" + first + ":3:3:x foo\n"},
+		{"position has column 1", []string{"show line 2", first, "--column"}, "2:1:bar\n"},
+		{"line numbers command", []string{"show foo with line numbers", first}, "     1\ta foo\n     3\tx foo\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stdout, _, err := runSsed(tt.args...)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if stdout != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, stdout)
+			}
+		})
+	}
+
+	if _, _, err := runSsed("show foo", first, "-n", "-i"); err == nil {
+		t.Error("expected -n with -i to be rejected")
+	}
+}
+
+func TestCLI_Color(t *testing.T) {
+	tmpFile := filepath.Join(t.TempDir(), "test.txt")
+	if err := os.WriteFile(tmpFile, []byte("a foo\nbar\n"), 0o644); err != nil {
+		t.Fatalf("failed to create temp file: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		args     []string
+		expected string
+	}{
+		{"matches", []string{"show foo", tmpFile, "--color=always"}, "a \x1b[1;31mfoo\x1b[0m\n"},
+		{
+			"prefixes",
+			[]string{"show foo", tmpFile, "--color=always", "-n"},
+			"\x1b[32m1\x1b[0m\x1b[36m:\x1b[0ma \x1b[1;31mfoo\x1b[0m\n",
+		},
+		{
+			"replaced text in previews",
+			[]string{"replace bar with baz", tmpFile, "--color=always", "--diff", "-U", "0"},
+			"\x1b[1m--- " + tmpFile + "\x1b[0m\n\x1b[1m+++ " + tmpFile + "\x1b[0m\n\x1b[36m@@ -2 +2 @@\x1b[0m\n" +
+				"\x1b[31m-ba\x1b[7mr\x1b[27m\x1b[0m\n\x1b[32m+ba\x1b[7mz\x1b[27m\x1b[0m\n",
+		},
+		{"never", []string{"show foo", tmpFile, "--color=never", "-n"}, "1:a foo\n"},
+		{"auto when not a terminal", []string{"show foo", tmpFile, "--color"}, "a foo\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stdout, _, err := runSsed(tt.args...)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if stdout != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, stdout)
+			}
+		})
+	}
+
+	if _, _, err := runSsed("show foo", tmpFile, "--color=sometimes"); err == nil {
+		t.Error("expected an invalid --color value to be rejected")
+	}
+}
+
+func TestColorEnabled(t *testing.T) {
+	tty, err := os.OpenFile("/dev/tty", os.O_WRONLY, 0)
+	if err != nil {
+		t.Skip("no terminal available")
+	}
+
+	defer tty.Close()
+
+	t.Setenv("TERM", "xterm")
+	t.Setenv("NO_COLOR", "")
+
+	if !colorEnabled("auto", tty) {
+		t.Error("expected colour on a terminal")
+	}
+
+	if colorEnabled("never", tty) {
+		t.Error("expected --color=never to turn colour off")
+	}
+
+	t.Setenv("NO_COLOR", "1")
+
+	if colorEnabled("auto", tty) {
+		t.Error("expected NO_COLOR to turn colour off")
+	}
+
+	if !colorEnabled("always", tty) {
+		t.Error("expected --color=always to override NO_COLOR")
+	}
+}
+
+func TestCLI_JSON(t *testing.T) {
+	tmpFile := filepath.Join(t.TempDir(), "test.txt")
+	if err := os.WriteFile(tmpFile, []byte("a foo b foo\nbar\n<foo>\n"), 0o644); err != nil {
+		t.Fatalf("failed to create temp file: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		args   []string
+		stdout string
+	}{
+		{
+			"show",
+			[]string{"show foo", tmpFile, "--json"},
+			`{"file":"` + tmpFile + `","line":1,"column":3,"text":"a foo b foo","match":"foo"}` + "\n" +
+				`{"file":"` + tmpFile + `","line":1,"column":9,"text":"a foo b foo","match":"foo"}` + "\n" +
+				`{"file":"` + tmpFile + `","line":3,"column":2,"text":"<foo>","match":"foo"}` + "\n",
+		},
+		{
+			"show by position",
+			[]string{"show line 2", tmpFile, "--json"},
+			`{"file":"` + tmpFile + `","line":2,"text":"bar"}` + "\n",
+		},
+		{
+			"count",
+			[]string{"count bar", tmpFile, "--json"},
+			`{"file":"` + tmpFile + `","line":2,"column":1,"text":"bar","match":"bar"}` + "\n",
+		},
+		{
+			"edit",
+			[]string{"replace foo with baz", tmpFile, "--json"},
+			`{"file":"` + tmpFile + `","changes":[{"line":1,"before":["a foo b foo"],"after":["a baz b baz"]},` +
+				`{"line":3,"before":["<foo>"],"after":["<baz>"]}]}` + "\n",
+		},
+		{
+			"delete",
+			[]string{"delete line 2", tmpFile, "--json"},
+			`{"file":"` + tmpFile + `","changes":[{"line":2,"before":["bar"],"after":[]}]}` + "\n",
+		},
+		{"no changes", []string{"replace missing with x", tmpFile, "--json"}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stdout, _, err := runSsed(tt.args...)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if stdout != tt.stdout {
+				t.Errorf("expected:\n%s\ngot:\n%s", tt.stdout, stdout)
+			}
+		})
+	}
+
+	if _, _, err := runSsed("replace foo with baz", tmpFile, "--json", "-i", "-q"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got, _ := os.ReadFile(tmpFile); string(got) != "a baz b baz\nbar\n<baz>\n" {
+		t.Errorf("expected --json with -i to edit the file, got %q", got)
+	}
+
+	if _, _, err := runSsed("replace foo with baz", tmpFile, "--json", "--diff"); err == nil {
+		t.Error("expected --json with --diff to be rejected")
+	}
+}
+
+func TestCLI_InPlaceLongLine(t *testing.T) {
+	tmpFile := filepath.Join(t.TempDir(), "bundle.min.js")
+	long := strings.Repeat("var a=1;", 2*1024*1024)
+
+	if err := os.WriteFile(tmpFile, []byte(long+"\n"), 0o644); err != nil {
+		t.Fatalf("failed to create temp file: %v", err)
+	}
+
+	if _, _, err := runSsed("replace 'var a' with 'let a'", tmpFile, "-i", "-q"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	afterContent, _ := os.ReadFile(tmpFile)
+	expected := strings.Repeat("let a=1;", 2*1024*1024) + "\n"
+
+	if string(afterContent) != expected {
+		t.Errorf("expected %d bytes of rewritten content, got %d bytes", len(expected), len(afterContent))
+	}
+}
+
 func TestCLI_QuietMode(t *testing.T) {
 	tmpDir := t.TempDir()
 	tmpFile := filepath.Join(tmpDir, "test.txt")
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const diffStatWidth = 50
+
+// diffName is the path used in diff headers. Relative paths get a "./"
+// prefix, which works both for patch -p0 and for git apply, whose default
+// -p1 strips exactly that component.
+func diffName(filename string) string {
+	if filename == "stdin" || filepath.IsAbs(filename) ||
+		strings.HasPrefix(filename, "./") || strings.HasPrefix(filename, "../") {
+		return filename
+	}
+
+	return "./" + filepath.ToSlash(filename)
+}
+
+// colorEnabled resolves a --color mode for output to w: always and never
+// mean what they say, auto colours terminals unless NO_COLOR is set or
+// TERM is dumb.
+func colorEnabled(mode string, w io.Writer) bool {
+	switch mode {
+	case "always":
+		return true
+	case "never":
+		return false
+	}
+
+	if os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+		return false
+	}
+
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+
+	fi, err := f.Stat()
+
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// writeDiffStat prints a git-style summary of the changed lines per file.
+func writeDiffStat(w io.Writer, reports []fileReport) {
+	nameWidth, maxChanges := 0, 0
+
+	var changed, inserted, deleted int
+
+	for _, r := range reports {
+		if r.inserted+r.deleted == 0 {
+			continue
+		}
+
+		nameWidth = max(nameWidth, len(r.name))
+		maxChanges = max(maxChanges, r.inserted+r.deleted)
+	}
+
+	for _, r := range reports {
+		total := r.inserted + r.deleted
+		if total == 0 {
+			continue
+		}
+
+		plus, minus := r.inserted, r.deleted
+		if maxChanges > diffStatWidth {
+			plus = (plus*diffStatWidth + maxChanges - 1) / maxChanges
+			minus = (minus*diffStatWidth + maxChanges - 1) / maxChanges
+		}
+
+		fmt.Fprintf(w, " %-*s | %d %s%s\n", nameWidth, r.name, total, strings.Repeat("+", plus), strings.Repeat("-", minus))
+
+		changed++
+		inserted += r.inserted
+		deleted += r.deleted
+	}
+
+	fmt.Fprintf(w, " %d %s changed, %d %s(+), %d %s(-)\n",
+		changed, plural(changed, "file", "files"),
+		inserted, plural(inserted, "insertion", "insertions"),
+		deleted, plural(deleted, "deletion", "deletions"))
+}
+
+func plural(n int, one, many string) string {
+	if n == 1 {
+		return one
+	}
+
+	return many
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// stagedFile is a file whose new content is ready in a temporary file but
+// not yet moved over it. orig holds the old content while committing.
+type stagedFile struct {
+	*pendingWrite
+	orig string
+}
+
+// transaction collects the in-place edits of an --atomic-all run so that
+// either all of them or none are applied. It is safe for concurrent use by
+// the -j workers.
+type transaction struct {
+	mu    sync.Mutex
+	files []stagedFile
+}
+
+func (t *transaction) stage(p *pendingWrite) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.files = append(t.files, stagedFile{pendingWrite: p})
+}
+
+// rollback discards the staged content; no file has been touched yet.
+func (t *transaction) rollback() {
+	if t == nil {
+		return
+	}
+
+	for _, f := range t.files {
+		f.discard()
+
+		if f.orig != "" {
+			os.Remove(f.orig)
+		}
+	}
+
+	t.files = nil
+}
+
+// keepOriginal sets the current content of f aside, so it can be put back if
+// a later rename fails, and for the backup and journal. A file that will be
+// overwritten in place has to be copied rather than linked.
+func keepOriginal(f *stagedFile) error {
+	orig, err := os.CreateTemp(filepath.Dir(f.target), ".ssed-orig-*")
+	if err != nil {
+		return err
+	}
+
+	f.orig = orig.Name()
+	orig.Close()
+
+	if !f.inPlace {
+		os.Remove(f.orig)
+
+		if err := os.Link(f.target, f.orig); err == nil {
+			return nil
+		}
+	}
+
+	return copyFile(f.target, f.orig)
+}
+
+// restoreOriginals puts back the content keepOriginal set aside. The copy of
+// a file that can't be restored is left where it is.
+func restoreOriginals(files []stagedFile) error {
+	var errs []error
+
+	for _, f := range files {
+		var err error
+		if f.inPlace {
+			if err = copyFile(f.orig, f.target); err == nil {
+				os.Remove(f.orig)
+			}
+		} else {
+			err = os.Rename(f.orig, f.target)
+		}
+
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s (its original content is in %s): %w", f.name, f.orig, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// commit backs up every staged file and then moves them all into place. If
+// that fails for one of them, the files done so far are restored, so the
+// tree is left as it was.
+func (t *transaction) commit(opts options, stderr io.Writer) error {
+	// The files were unlocked when their processing finished; check nobody
+	// changed them in the meantime. Each lock is released before taking the
+	// next, so the number of open files doesn't grow with the run.
+	for _, f := range t.files {
+		if f.guard == nil {
+			continue
+		}
+
+		err := f.guard.acquire()
+		if err == nil {
+			err = f.guard.verify()
+			f.guard.release()
+		}
+
+		if err != nil {
+			t.rollback()
+
+			return fmt.Errorf("%w; no files were changed", err)
+		}
+	}
+
+	for i := range t.files {
+		if err := keepOriginal(&t.files[i]); err != nil {
+			name := t.files[i].name
+			t.rollback()
+
+			return fmt.Errorf("error preparing %s: %w; no files were changed", name, err)
+		}
+	}
+
+	// Back up every file before the first one is replaced.
+	for _, f := range t.files {
+		if err := saveOriginal(f.name, f.orig, true, f.hash(), opts, stderr); err != nil {
+			t.rollback()
+
+			return fmt.Errorf("%w; no files were changed", err)
+		}
+	}
+
+	for i, f := range t.files {
+		if err := f.commit(); err != nil {
+			// A failed copy may have left a file overwritten in place half
+			// written, so it is restored as well.
+			written := t.files[:i]
+			if f.inPlace {
+				written = t.files[:i+1]
+			}
+
+			if restoreErr := restoreOriginals(written); restoreErr != nil {
+				for _, staged := range t.files {
+					staged.discard()
+				}
+
+				t.files = nil
+
+				return fmt.Errorf("error writing file %s: %w; restoring the files already written failed: %w", f.name, err, restoreErr)
+			}
+
+			t.rollback()
+
+			return fmt.Errorf("error writing file %s: %w; no files were changed", f.name, err)
+		}
+	}
+
+	var firstErr error
+
+	for _, f := range t.files {
+		os.Remove(f.orig)
+
+		if opts.preserveMtime {
+			if err := preserveMtime(f.target, f.info); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+
+		if !opts.quiet {
+			fmt.Fprintf(stderr, "Modified: %s\n", f.name)
+		}
+	}
+
+	t.files = nil
+
+	return firstErr
+}
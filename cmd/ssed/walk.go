@@ -0,0 +1,433 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// globPattern is a gitignore-style glob: "*" and "?" stay within one path
+// segment, "**" spans any number of them, and a pattern without a slash
+// matches the base name at any depth.
+type globPattern struct {
+	re       *regexp.Regexp
+	anchored bool
+	negate   bool
+	dirOnly  bool
+}
+
+func compileGlob(pattern string) (globPattern, error) {
+	var g globPattern
+
+	if strings.HasPrefix(pattern, "!") {
+		g.negate = true
+		pattern = pattern[1:]
+	}
+
+	if strings.HasSuffix(pattern, "/") {
+		g.dirOnly = true
+		pattern = strings.TrimRight(pattern, "/")
+	}
+
+	if strings.Contains(pattern, "/") {
+		g.anchored = true
+		pattern = strings.TrimPrefix(pattern, "/")
+	}
+
+	var b strings.Builder
+
+	b.WriteString("^")
+
+	for i := 0; i < len(pattern); i++ {
+		ch := pattern[i]
+
+		switch {
+		case strings.HasPrefix(pattern[i:], "**/") && (i == 0 || pattern[i-1] == '/'):
+			b.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case ch == '*':
+			b.WriteString("[^/]*")
+		case ch == '?':
+			b.WriteString("[^/]")
+		case ch == '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 {
+				b.WriteString(`\[`)
+
+				break
+			}
+
+			class := pattern[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+
+			b.WriteString("[" + class + "]")
+			i += end + 1
+		case ch == '\\' && i+1 < len(pattern):
+			i++
+			b.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		default:
+			b.WriteString(regexp.QuoteMeta(string(ch)))
+		}
+	}
+
+	b.WriteString("$")
+
+	re, err := regexp.Compile(b.String())
+	if err != nil {
+		return g, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+	}
+
+	g.re = re
+
+	return g, nil
+}
+
+// match reports whether the slash-separated relative path rel matches.
+func (g globPattern) match(rel string) bool {
+	if g.anchored {
+		return g.re.MatchString(rel)
+	}
+
+	return g.re.MatchString(path.Base(rel))
+}
+
+func compileGlobs(patterns []string) ([]globPattern, error) {
+	globs := make([]globPattern, 0, len(patterns))
+
+	for _, pattern := range patterns {
+		g, err := compileGlob(pattern)
+		if err != nil {
+			return nil, err
+		}
+
+		globs = append(globs, g)
+	}
+
+	return globs, nil
+}
+
+// ignoreFile holds the rules of one .gitignore or .ignore file, which apply
+// to paths below the absolute directory dir.
+type ignoreFile struct {
+	dir   string
+	rules []globPattern
+}
+
+// readIgnoreFile reads the ignore file at filename, whose rules apply below
+// dir.
+func readIgnoreFile(dir, filename string) (*ignoreFile, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	defer f.Close()
+
+	ig := &ignoreFile{dir: dir}
+	scanner := bufio.NewScanner(f)
+
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		rule, err := compileGlob(line)
+		if err != nil {
+			continue
+		}
+
+		ig.rules = append(ig.rules, rule)
+	}
+
+	return ig, scanner.Err()
+}
+
+type walker struct {
+	include  []globPattern
+	exclude  []globPattern
+	noIgnore bool
+	visited  map[string]bool
+	files    []string
+}
+
+func matchesAny(globs []globPattern, rel string, isDir bool) bool {
+	for _, g := range globs {
+		if g.dirOnly && !isDir {
+			continue
+		}
+
+		if g.match(rel) || (isDir && g.match(rel+"/")) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// readIgnoreFiles reads the .gitignore and .ignore files of dir.
+func readIgnoreFiles(dir string, ignores []*ignoreFile) ([]*ignoreFile, error) {
+	for _, name := range []string{".gitignore", ".ignore"} {
+		ig, err := readIgnoreFile(dir, filepath.Join(dir, name))
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s: %w", filepath.Join(dir, name), err)
+		}
+
+		if ig != nil {
+			ignores = append(ignores[:len(ignores):len(ignores)], ig)
+		}
+	}
+
+	return ignores, nil
+}
+
+// parentIgnores returns the ignore rules that apply to the absolute
+// directory root from outside it, as git sees them: .git/info/exclude and
+// the ignore files of the directories from the repository's top down to
+// root's parent. Outside a repository there are none.
+func parentIgnores(root string) ([]*ignoreFile, error) {
+	var parents []string
+
+	top, gitDir := "", ""
+
+	for dir := root; ; {
+		if info, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			top = dir
+
+			// Worktrees and submodules have a .git file instead; their
+			// excludes live elsewhere.
+			if info.IsDir() {
+				gitDir = filepath.Join(dir, ".git")
+			}
+
+			break
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return nil, nil
+		}
+
+		parents = append(parents, parent)
+		dir = parent
+	}
+
+	var ignores []*ignoreFile
+
+	if gitDir != "" {
+		exclude := filepath.Join(gitDir, "info", "exclude")
+
+		ig, err := readIgnoreFile(top, exclude)
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s: %w", exclude, err)
+		}
+
+		if ig != nil {
+			ignores = append(ignores, ig)
+		}
+	}
+
+	for i := len(parents) - 1; i >= 0; i-- {
+		var err error
+
+		if ignores, err = readIgnoreFiles(parents[i], ignores); err != nil {
+			return nil, err
+		}
+	}
+
+	return ignores, nil
+}
+
+// ignored applies the ignore files in order; the last matching rule wins,
+// so "!keep.txt" can re-include something an earlier rule ignored. name is
+// absolute.
+func ignored(ignores []*ignoreFile, name string, isDir bool) bool {
+	result := false
+
+	for _, ig := range ignores {
+		rel, err := filepath.Rel(ig.dir, name)
+		if err != nil {
+			continue
+		}
+
+		rel = filepath.ToSlash(rel)
+
+		for _, rule := range ig.rules {
+			if rule.dirOnly && !isDir {
+				continue
+			}
+
+			if rule.match(rel) {
+				result = !rule.negate
+			}
+		}
+	}
+
+	return result
+}
+
+// walk collects the files below dir, which is absDir as an absolute path
+// and realDir as an absolute path with symlinks resolved.
+func (w *walker) walk(root, dir, absDir, realDir string, ignores []*ignoreFile) error {
+	if w.visited[realDir] {
+		return nil
+	}
+
+	w.visited[realDir] = true
+
+	if !w.noIgnore {
+		var err error
+
+		if ignores, err = readIgnoreFiles(absDir, ignores); err != nil {
+			return err
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("error reading directory %s: %w", dir, err)
+	}
+
+	for _, entry := range entries {
+		name := filepath.Join(dir, entry.Name())
+		realName := filepath.Join(realDir, entry.Name())
+		isDir := entry.IsDir()
+
+		if entry.Type()&os.ModeSymlink != 0 {
+			target, err := filepath.EvalSymlinks(name)
+			if err != nil {
+				continue // dangling link
+			}
+
+			info, err := os.Stat(target)
+			if err != nil {
+				continue
+			}
+
+			// A link with an absolute target resolves to an absolute path,
+			// so the visited keys are all made absolute.
+			if realName, err = filepath.Abs(target); err != nil {
+				return err
+			}
+
+			isDir = info.IsDir()
+		} else if !isDir && !entry.Type().IsRegular() {
+			continue
+		}
+
+		rel, err := filepath.Rel(root, name)
+		if err != nil {
+			return err
+		}
+
+		rel = filepath.ToSlash(rel)
+
+		if isDir && strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+
+		absName := filepath.Join(absDir, entry.Name())
+
+		if matchesAny(w.exclude, rel, isDir) || ignored(ignores, absName, isDir) {
+			continue
+		}
+
+		if isDir {
+			if err := w.walk(root, name, absName, realName, ignores); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if len(w.include) > 0 && !matchesAny(w.include, rel, false) {
+			continue
+		}
+
+		if !w.visited[realName] {
+			w.visited[realName] = true
+			w.files = append(w.files, name)
+		}
+	}
+
+	return nil
+}
+
+// expandPaths checks the file arguments and, with -r, replaces directories
+// by the files below them. With -r and no arguments the current directory
+// is searched.
+func expandPaths(args []string, opts options) ([]string, error) {
+	if opts.recursive && len(args) == 0 {
+		args = []string{"."}
+	}
+
+	include, err := compileGlobs(opts.include)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --include: %w", err)
+	}
+
+	exclude, err := compileGlobs(opts.exclude)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --exclude: %w", err)
+	}
+
+	w := &walker{include: include, exclude: exclude, noIgnore: opts.noIgnore, visited: map[string]bool{}}
+
+	for _, arg := range args {
+		fi, err := os.Stat(arg)
+		if err != nil {
+			return nil, fmt.Errorf("error accessing file %s: %w", arg, err)
+		}
+
+		if !fi.IsDir() {
+			w.files = append(w.files, arg)
+
+			continue
+		}
+
+		if !opts.recursive {
+			return nil, fmt.Errorf("%s is a directory (use -r to process it recursively)", arg)
+		}
+
+		realDir, err := filepath.EvalSymlinks(arg)
+		if err == nil {
+			realDir, err = filepath.Abs(realDir)
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("error accessing file %s: %w", arg, err)
+		}
+
+		absDir, err := filepath.Abs(arg)
+		if err != nil {
+			return nil, fmt.Errorf("error accessing file %s: %w", arg, err)
+		}
+
+		var ignores []*ignoreFile
+
+		if !opts.noIgnore {
+			if ignores, err = parentIgnores(absDir); err != nil {
+				return nil, err
+			}
+		}
+
+		if err := w.walk(arg, arg, absDir, realDir, ignores); err != nil {
+			return nil, err
+		}
+	}
+
+	return w.files, nil
+}
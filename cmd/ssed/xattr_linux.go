@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"syscall"
+)
+
+// copyXattrs copies the extended attributes of src to dst, which includes
+// POSIX ACLs and SELinux labels. Attributes the filesystem or our
+// privileges don't allow are skipped.
+func copyXattrs(src, dst string) error {
+	size, err := syscall.Listxattr(src, nil)
+	if err != nil || size == 0 {
+		return ignoreXattrError(err)
+	}
+
+	list := make([]byte, size)
+
+	size, err = syscall.Listxattr(src, list)
+	if err != nil {
+		return ignoreXattrError(err)
+	}
+
+	for _, name := range bytes.Split(list[:size], []byte{0}) {
+		if len(name) == 0 {
+			continue
+		}
+
+		attr := string(name)
+
+		n, err := syscall.Getxattr(src, attr, nil)
+		if err != nil {
+			if ignoreXattrError(err) == nil {
+				continue
+			}
+
+			return err
+		}
+
+		value := make([]byte, n)
+
+		n, err = syscall.Getxattr(src, attr, value)
+		if err == nil {
+			err = syscall.Setxattr(dst, attr, value[:n], 0)
+		}
+
+		if err := ignoreXattrError(err); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func ignoreXattrError(err error) error {
+	if errors.Is(err, syscall.ENOTSUP) || errors.Is(err, syscall.EPERM) || errors.Is(err, syscall.ENODATA) {
+		return nil
+	}
+
+	return err
+}
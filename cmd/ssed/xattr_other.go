@@ -0,0 +1,7 @@
+//go:build !linux
+
+package main
+
+func copyXattrs(src, dst string) error {
+	return nil
+}
@@ -26,6 +26,14 @@ func (lr LineRange) HasRange() bool {
 	return lr.End > 0
 }
 
+func (lr LineRange) Contains(lineNum int) bool {
+	if lr.HasRange() {
+		return lineNum >= lr.Start && lineNum <= lr.End
+	}
+
+	return lineNum == lr.Start
+}
+
 type InsertPosition int
 
 const (
@@ -107,10 +115,18 @@ const (
 	TransformTrim
 	TransformTrimLeading
 	TransformTrimTrailing
+	TransformIndent
+	TransformDedent
+	TransformDedentCommon
+	TransformTabsToSpaces
+	TransformSpacesToTabs
 )
 
 type TransformCommand struct {
-	Type TransformType
+	Type      TransformType
+	Amount    int
+	UseTabs   bool
+	LineRange *LineRange
 }
 
 func (t *TransformCommand) commandNode() {
@@ -120,6 +136,131 @@ func (t *TransformCommand) TokenLiteral() string {
 	return "TRANSFORM"
 }
 
+type LineEndingStyle int
+
+const (
+	LineEndingLF LineEndingStyle = iota
+	LineEndingCRLF
+)
+
+type LineEndingCommand struct {
+	Style LineEndingStyle
+}
+
+func (l *LineEndingCommand) commandNode() {
+}
+
+func (l *LineEndingCommand) TokenLiteral() string {
+	return "LINEENDING"
+}
+
+type EncodingCommand struct {
+	Name string
+}
+
+func (e *EncodingCommand) commandNode() {
+}
+
+func (e *EncodingCommand) TokenLiteral() string {
+	return "ENCODING"
+}
+
+type BlankLinesType int
+
+const (
+	BlankDeleteEmpty BlankLinesType = iota
+	BlankDeleteBlank
+	BlankSqueeze
+	BlankDeleteLeading
+	BlankDeleteTrailing
+)
+
+type BlankLinesCommand struct {
+	Type BlankLinesType
+}
+
+func (b *BlankLinesCommand) commandNode() {
+}
+
+func (b *BlankLinesCommand) TokenLiteral() string {
+	return "BLANKLINES"
+}
+
+type RemoveType int
+
+const (
+	RemoveText RemoveType = iota
+	RemoveAfter
+	RemoveBefore
+	RemoveFrom
+	RemoveBetween
+)
+
+type RemoveCommand struct {
+	Type    RemoveType
+	Target  string
+	IsRegex bool
+	End     string
+}
+
+func (r *RemoveCommand) commandNode() {
+}
+
+func (r *RemoveCommand) TokenLiteral() string {
+	return "REMOVE"
+}
+
+type AffixPosition int
+
+const (
+	AffixPrefix AffixPosition = iota
+	AffixSuffix
+	AffixSurround
+	AffixToggleComment
+)
+
+type AffixCommand struct {
+	Position     AffixPosition
+	Text         string
+	ClosingText  string
+	Match        string
+	MatchIsRegex bool
+	Target       string
+	IsRegex      bool
+	PatternType  PatternType
+	Negated      bool
+	WholeWord    bool
+	LineRange    *LineRange
+}
+
+func (a *AffixCommand) commandNode() {
+}
+
+func (a *AffixCommand) TokenLiteral() string {
+	return "AFFIX"
+}
+
+type LayoutType int
+
+const (
+	LayoutWrap LayoutType = iota
+	LayoutFill
+	LayoutCenter
+	LayoutRightAlign
+)
+
+type LayoutCommand struct {
+	Type  LayoutType
+	Width int
+}
+
+func (l *LayoutCommand) commandNode() {
+}
+
+func (l *LayoutCommand) TokenLiteral() string {
+	return "LAYOUT"
+}
+
 type CountCommand struct {
 	Target  string
 	IsRegex bool
@@ -0,0 +1,518 @@
+// Package diff computes line diffs with Myers' algorithm and formats them as
+// unified diffs.
+package diff
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+type OpKind int
+
+const (
+	OpEqual OpKind = iota
+	OpDelete
+	OpInsert
+)
+
+// Line is one line of an edit script. OldLine and NewLine are 1-based line
+// numbers in the old and new text; the one that doesn't apply is 0.
+type Line struct {
+	Kind    OpKind
+	Text    string
+	OldLine int
+	NewLine int
+}
+
+// Hunk is a run of changes with the surrounding context lines.
+type Hunk struct {
+	OldStart int
+	OldLines int
+	NewStart int
+	NewLines int
+	Lines    []Line
+}
+
+// maxCost bounds the edit distance searched for in one region. Beyond it the
+// region is reported as replaced wholesale, which keeps pathological inputs
+// (two unrelated 100k-line files) fast at the price of a longer diff.
+const maxCost = 4096
+
+// SplitLines splits text into lines that keep their terminator, so a missing
+// final newline survives the round trip.
+func SplitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	return lines
+}
+
+type differ struct {
+	a, b       []int
+	delA, insB []bool
+	vf, vb     []int
+}
+
+// Lines returns the edit script turning a into b.
+func Lines(a, b []string) []Line {
+	ids := make(map[string]int)
+
+	intern := func(lines []string) []int {
+		out := make([]int, len(lines))
+
+		for i, line := range lines {
+			id, ok := ids[line]
+			if !ok {
+				id = len(ids)
+				ids[line] = id
+			}
+
+			out[i] = id
+		}
+
+		return out
+	}
+
+	d := &differ{
+		a:    intern(a),
+		b:    intern(b),
+		delA: make([]bool, len(a)),
+		insB: make([]bool, len(b)),
+	}
+
+	d.compare(0, len(a), 0, len(b))
+
+	var script []Line
+
+	i, j := 0, 0
+
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && d.delA[i]:
+			script = append(script, Line{Kind: OpDelete, Text: a[i], OldLine: i + 1})
+			i++
+		case j < len(b) && d.insB[j]:
+			script = append(script, Line{Kind: OpInsert, Text: b[j], NewLine: j + 1})
+			j++
+		default:
+			script = append(script, Line{Kind: OpEqual, Text: a[i], OldLine: i + 1, NewLine: j + 1})
+			i++
+			j++
+		}
+	}
+
+	return script
+}
+
+func (d *differ) compare(aLo, aHi, bLo, bHi int) {
+	for aLo < aHi && bLo < bHi && d.a[aLo] == d.b[bLo] {
+		aLo++
+		bLo++
+	}
+
+	for aLo < aHi && bLo < bHi && d.a[aHi-1] == d.b[bHi-1] {
+		aHi--
+		bHi--
+	}
+
+	if aLo == aHi || bLo == bHi {
+		d.replace(aLo, aHi, bLo, bHi)
+
+		return
+	}
+
+	x, y, u, v, ok := d.middleSnake(aLo, aHi, bLo, bHi)
+	if !ok || (x == aLo && y == bLo && u == aHi && v == bHi) {
+		d.replace(aLo, aHi, bLo, bHi)
+
+		return
+	}
+
+	d.compare(aLo, x, bLo, y)
+	d.compare(u, aHi, v, bHi)
+}
+
+func (d *differ) replace(aLo, aHi, bLo, bHi int) {
+	for i := aLo; i < aHi; i++ {
+		d.delA[i] = true
+	}
+
+	for j := bLo; j < bHi; j++ {
+		d.insB[j] = true
+	}
+}
+
+// middleSnake runs the forward and backward searches of the linear-space
+// Myers algorithm until they overlap and returns the snake where they meet.
+func (d *differ) middleSnake(aLo, aHi, bLo, bHi int) (int, int, int, int, bool) {
+	n, m := aHi-aLo, bHi-bLo
+	delta := n - m
+	odd := delta%2 != 0
+	limit := min((n+m+1)/2, maxCost)
+	off := limit + 1
+
+	if len(d.vf) < 2*off+1 {
+		d.vf = make([]int, 2*off+1)
+		d.vb = make([]int, 2*off+1)
+	}
+
+	vf, vb := d.vf, d.vb
+	vf[off+1] = 0
+	vb[off+1] = 0
+
+	for depth := 0; depth <= limit; depth++ {
+		for k := -depth; k <= depth; k += 2 {
+			var x int
+			if k == -depth || (k != depth && vf[off+k-1] < vf[off+k+1]) {
+				x = vf[off+k+1]
+			} else {
+				x = vf[off+k-1] + 1
+			}
+
+			y := x - k
+			x0, y0 := x, y
+
+			for x < n && y < m && d.a[aLo+x] == d.b[bLo+y] {
+				x++
+				y++
+			}
+
+			vf[off+k] = x
+
+			if kr := delta - k; odd && kr >= -(depth-1) && kr <= depth-1 && x+vb[off+kr] >= n {
+				return aLo + x0, bLo + y0, aLo + x, bLo + y, true
+			}
+		}
+
+		for k := -depth; k <= depth; k += 2 {
+			var x int
+			if k == -depth || (k != depth && vb[off+k-1] < vb[off+k+1]) {
+				x = vb[off+k+1]
+			} else {
+				x = vb[off+k-1] + 1
+			}
+
+			y := x - k
+			x0, y0 := x, y
+
+			for x < n && y < m && d.a[aHi-1-x] == d.b[bHi-1-y] {
+				x++
+				y++
+			}
+
+			vb[off+k] = x
+
+			if kf := delta - k; !odd && kf >= -depth && kf <= depth && x+vf[off+kf] >= n {
+				return aLo + n - x, bLo + m - y, aLo + n - x0, bLo + m - y0, true
+			}
+		}
+	}
+
+	return 0, 0, 0, 0, false
+}
+
+// Hunks groups an edit script into hunks with up to context unchanged lines
+// around each change; changes closer than 2*context share a hunk.
+func Hunks(script []Line, context int) []Hunk {
+	var hunks []Hunk
+
+	oldBefore, newBefore := 0, 0
+
+	for i := 0; i < len(script); {
+		if script[i].Kind == OpEqual {
+			oldBefore++
+			newBefore++
+			i++
+
+			continue
+		}
+
+		start := i
+		for start > 0 && i-start < context && script[start-1].Kind == OpEqual {
+			start--
+			oldBefore--
+			newBefore--
+		}
+
+		end := i
+
+		for end < len(script) {
+			if script[end].Kind != OpEqual {
+				end++
+
+				continue
+			}
+
+			run := end
+			for run < len(script) && script[run].Kind == OpEqual {
+				run++
+			}
+
+			if run == len(script) || run-end > 2*context {
+				end = min(end+context, run)
+
+				break
+			}
+
+			end = run
+		}
+
+		h := Hunk{Lines: script[start:end]}
+
+		for _, line := range h.Lines {
+			if line.Kind != OpInsert {
+				h.OldLines++
+			}
+
+			if line.Kind != OpDelete {
+				h.NewLines++
+			}
+		}
+
+		// An empty side starts at the line before the hunk, as in GNU diff.
+		h.OldStart = oldBefore
+		if h.OldLines > 0 {
+			h.OldStart++
+		}
+
+		h.NewStart = newBefore
+		if h.NewLines > 0 {
+			h.NewStart++
+		}
+
+		hunks = append(hunks, h)
+		oldBefore += h.OldLines
+		newBefore += h.NewLines
+		i = end
+	}
+
+	return hunks
+}
+
+// Patch applies the hunks for which accept returns true to old, the text the
+// hunks were computed from. The lines of rejected hunks stay as they were.
+func Patch(old []string, hunks []Hunk, accept func(i int, h Hunk) bool) []string {
+	var out []string
+
+	pos := 0
+
+	for i, h := range hunks {
+		start := h.OldStart
+		if h.OldLines > 0 {
+			start--
+		}
+
+		out = append(out, old[pos:start]...)
+
+		if accept(i, h) {
+			for _, line := range h.Lines {
+				if line.Kind != OpDelete {
+					out = append(out, line.Text)
+				}
+			}
+		} else {
+			out = append(out, old[start:start+h.OldLines]...)
+		}
+
+		pos = start + h.OldLines
+	}
+
+	return append(out, old[pos:]...)
+}
+
+// The ANSI colours of a coloured diff, shared with the other places that
+// show changes or matches on a terminal.
+const (
+	ColorHeader = "\x1b[1m"
+	ColorHunk   = "\x1b[36m"
+	ColorDelete = "\x1b[31m"
+	ColorInsert = "\x1b[32m"
+	ColorReset  = "\x1b[0m"
+	// ColorReplaced marks the part of a changed line that was replaced.
+	ColorReplaced    = "\x1b[7m"
+	ColorReplacedEnd = "\x1b[27m"
+)
+
+// Unified writes hunks as a unified diff of oldName and newName. Lines keep
+// their own terminators; a line without one is followed by the usual
+// "\ No newline at end of file" marker so patch can reproduce it.
+func Unified(w io.Writer, oldName, newName string, hunks []Hunk, color bool) error {
+	if len(hunks) == 0 {
+		return nil
+	}
+
+	paint := func(code, text string) string {
+		if !color {
+			return text
+		}
+
+		return code + text + ColorReset
+	}
+
+	var b strings.Builder
+
+	b.WriteString(paint(ColorHeader, "--- "+oldName) + "\n")
+	b.WriteString(paint(ColorHeader, "+++ "+newName) + "\n")
+
+	for _, h := range hunks {
+		b.WriteString(paint(ColorHunk, fmt.Sprintf("@@ -%s +%s @@", hunkRange(h.OldStart, h.OldLines), hunkRange(h.NewStart, h.NewLines))) + "\n")
+
+		for i, text := range FormatLines(h.Lines, color) {
+			b.WriteString(text + "\n")
+
+			if !strings.HasSuffix(h.Lines[i].Text, "\n") {
+				b.WriteString("\\ No newline at end of file\n")
+			}
+		}
+
+		if _, err := io.WriteString(w, b.String()); err != nil {
+			return err
+		}
+
+		b.Reset()
+	}
+
+	return nil
+}
+
+// FormatLines returns lines as they appear in a unified diff, each with its
+// " ", "-" or "+" prefix and without its terminator. With color, deleted and
+// inserted lines are coloured and the replaced part of each is highlighted.
+func FormatLines(lines []Line, color bool) []string {
+	var replaced map[int][2]int
+	if color {
+		replaced = ReplacedSpans(lines)
+	}
+
+	out := make([]string, len(lines))
+
+	for i, line := range lines {
+		prefix, code := " ", ""
+
+		switch line.Kind {
+		case OpDelete:
+			prefix, code = "-", ColorDelete
+		case OpInsert:
+			prefix, code = "+", ColorInsert
+		}
+
+		text := strings.TrimSuffix(line.Text, "\n")
+		if span, ok := replaced[i]; ok {
+			text = text[:span[0]] + ColorReplaced + text[span[0]:span[1]] + ColorReplacedEnd + text[span[1]:]
+		}
+
+		text = prefix + text
+		if color && code != "" {
+			text = code + text + ColorReset
+		}
+
+		out[i] = text
+	}
+
+	return out
+}
+
+// ReplacedSpans pairs the deleted and inserted lines of a change that
+// replaces lines one for one and returns, by index into lines, the byte
+// range of each line that differs from its partner: what lies between their
+// common prefix and suffix. Lines with nothing in common are left out, as
+// highlighting all of them says nothing.
+func ReplacedSpans(lines []Line) map[int][2]int {
+	spans := make(map[int][2]int)
+
+	for i := 0; i < len(lines); {
+		if lines[i].Kind != OpDelete {
+			i++
+
+			continue
+		}
+
+		deleted := i
+		for i < len(lines) && lines[i].Kind == OpDelete {
+			i++
+		}
+
+		inserted := i
+		for i < len(lines) && lines[i].Kind == OpInsert {
+			i++
+		}
+
+		n := inserted - deleted
+		if i-inserted != n {
+			continue
+		}
+
+		for k := 0; k < n; k++ {
+			old := strings.TrimSuffix(lines[deleted+k].Text, "\n")
+			new := strings.TrimSuffix(lines[inserted+k].Text, "\n")
+
+			prefix, suffix := commonAffixes(old, new)
+			if prefix == 0 && suffix == 0 {
+				continue
+			}
+
+			spans[deleted+k] = [2]int{prefix, len(old) - suffix}
+			spans[inserted+k] = [2]int{prefix, len(new) - suffix}
+		}
+	}
+
+	return spans
+}
+
+// commonAffixes returns the lengths of the longest common prefix and suffix
+// of a and b that don't overlap or split a UTF-8 sequence.
+func commonAffixes(a, b string) (int, int) {
+	limit := min(len(a), len(b))
+
+	prefix := 0
+	for prefix < limit && a[prefix] == b[prefix] {
+		prefix++
+	}
+
+	for prefix > 0 && prefix < len(a) && !utf8.RuneStart(a[prefix]) {
+		prefix--
+	}
+
+	suffix := 0
+	for suffix < limit-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	for suffix > 0 && !utf8.RuneStart(a[len(a)-suffix]) {
+		suffix--
+	}
+
+	return prefix, suffix
+}
+
+func hunkRange(start, count int) string {
+	if count == 1 {
+		return fmt.Sprintf("%d", start)
+	}
+
+	return fmt.Sprintf("%d,%d", start, count)
+}
+
+// Stat counts the inserted and deleted lines of an edit script.
+func Stat(script []Line) (int, int) {
+	inserted, deleted := 0, 0
+
+	for _, line := range script {
+		switch line.Kind {
+		case OpInsert:
+			inserted++
+		case OpDelete:
+			deleted++
+		}
+	}
+
+	return inserted, deleted
+}
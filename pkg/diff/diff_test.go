@@ -0,0 +1,254 @@
+package diff
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func apply(script []Line) ([]string, []string) {
+	var a, b []string
+
+	for _, line := range script {
+		if line.Kind != OpInsert {
+			a = append(a, line.Text)
+		}
+
+		if line.Kind != OpDelete {
+			b = append(b, line.Text)
+		}
+	}
+
+	return a, b
+}
+
+func lcsLength(a, b []string) int {
+	dp := make([][]int, len(a)+1)
+	for i := range dp {
+		dp[i] = make([]int, len(b)+1)
+	}
+
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				dp[i][j] = dp[i+1][j+1] + 1
+			} else {
+				dp[i][j] = max(dp[i+1][j], dp[i][j+1])
+			}
+		}
+	}
+
+	return dp[0][0]
+}
+
+func TestLinesIsMinimal(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	for n := 0; n < 500; n++ {
+		a := make([]string, rng.Intn(30))
+		b := make([]string, rng.Intn(30))
+
+		for i := range a {
+			a[i] = string(rune('a' + rng.Intn(4)))
+		}
+
+		for i := range b {
+			b[i] = string(rune('a' + rng.Intn(4)))
+		}
+
+		script := Lines(a, b)
+
+		gotA, gotB := apply(script)
+		if strings.Join(gotA, "") != strings.Join(a, "") || strings.Join(gotB, "") != strings.Join(b, "") {
+			t.Fatalf("script does not reproduce inputs for %q -> %q", a, b)
+		}
+
+		inserted, deleted := Stat(script)
+		if want := len(a) + len(b) - 2*lcsLength(a, b); inserted+deleted != want {
+			t.Fatalf("%q -> %q: expected %d edits, got %d", a, b, want, inserted+deleted)
+		}
+	}
+}
+
+func TestUnified(t *testing.T) {
+	tests := []struct {
+		name     string
+		old      string
+		new      string
+		context  int
+		expected string
+	}{
+		{
+			"single change",
+			"a\nb\nc\nd\ne\nf\ng\nh\n",
+			"a\nb\nc\nd\nE\nf\ng\nh\n",
+			3,
+			"--- ./f\n+++ ./f\n@@ -2,7 +2,7 @@\n b\n c\n d\n-e\n+E\n f\n g\n h\n",
+		},
+		{
+			"separate hunks",
+			"1\n2\n3\n4\n5\n6\n7\n8\n9\n",
+			"x\n2\n3\n4\n5\n6\n7\n8\ny\n",
+			1,
+			"--- ./f\n+++ ./f\n@@ -1,2 +1,2 @@\n-1\n+x\n 2\n@@ -8,2 +8,2 @@\n 8\n-9\n+y\n",
+		},
+		{
+			"insertion into empty file",
+			"",
+			"new\n",
+			3,
+			"--- ./f\n+++ ./f\n@@ -0,0 +1 @@\n+new\n",
+		},
+		{
+			"deleted line",
+			"a\nb\nc\n",
+			"a\nc\n",
+			0,
+			"--- ./f\n+++ ./f\n@@ -2 +1,0 @@\n-b\n",
+		},
+		{
+			"missing final newline",
+			"a\nb",
+			"a\nc",
+			3,
+			"--- ./f\n+++ ./f\n@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+c\n\\ No newline at end of file\n",
+		},
+		{
+			"no changes",
+			"a\n",
+			"a\n",
+			3,
+			"",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out strings.Builder
+
+			hunks := Hunks(Lines(SplitLines(tt.old), SplitLines(tt.new)), tt.context)
+			if err := Unified(&out, "./f", "./f", hunks, false); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if out.String() != tt.expected {
+				t.Errorf("expected:\n%s\ngot:\n%s", tt.expected, out.String())
+			}
+		})
+	}
+}
+
+func TestUnifiedColor(t *testing.T) {
+	var out strings.Builder
+
+	hunks := Hunks(Lines(SplitLines("a\nfoo bar\n"), SplitLines("a\nfoo baz\n")), 0)
+	if err := Unified(&out, "./f", "./f", hunks, true); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := "\x1b[1m--- ./f\x1b[0m\n\x1b[1m+++ ./f\x1b[0m\n\x1b[36m@@ -2 +2 @@\x1b[0m\n" +
+		"\x1b[31m-foo ba\x1b[7mr\x1b[27m\x1b[0m\n\x1b[32m+foo ba\x1b[7mz\x1b[27m\x1b[0m\n"
+	if out.String() != expected {
+		t.Errorf("expected %q, got %q", expected, out.String())
+	}
+}
+
+func TestFormatLines(t *testing.T) {
+	lines := Lines(SplitLines("a\nfoo bar\n"), SplitLines("a\nfoo baz\n"))
+
+	tests := []struct {
+		name     string
+		color    bool
+		expected []string
+	}{
+		{"plain", false, []string{" a", "-foo bar", "+foo baz"}},
+		{"color", true, []string{
+			" a",
+			ColorDelete + "-foo ba" + ColorReplaced + "r" + ColorReplacedEnd + ColorReset,
+			ColorInsert + "+foo ba" + ColorReplaced + "z" + ColorReplacedEnd + ColorReset,
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := FormatLines(lines, tt.color)
+			if strings.Join(got, "\n") != strings.Join(tt.expected, "\n") {
+				t.Errorf("expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestReplacedSpans(t *testing.T) {
+	tests := []struct {
+		name     string
+		old      string
+		new      string
+		expected map[int][2]int
+	}{
+		{"middle", "say hello world\n", "say goodbye world\n", map[int][2]int{0: {4, 9}, 1: {4, 11}}},
+		{"insertion", "ab\n", "aXb\n", map[int][2]int{0: {1, 1}, 1: {1, 2}}},
+		{"nothing in common", "abc\n", "xyz\n", map[int][2]int{}},
+		{"uneven", "a1\na2\n", "b1\n", map[int][2]int{}},
+		{"multi-byte", "caf\u00e9\n", "caf\u00e8\n", map[int][2]int{0: {3, 5}, 1: {3, 5}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ReplacedSpans(Lines(SplitLines(tt.old), SplitLines(tt.new)))
+			if len(got) != len(tt.expected) {
+				t.Fatalf("expected %v, got %v", tt.expected, got)
+			}
+
+			for i, span := range tt.expected {
+				if got[i] != span {
+					t.Errorf("expected %v, got %v", tt.expected, got)
+				}
+			}
+		})
+	}
+}
+
+func TestLinesLargeInput(t *testing.T) {
+	a := make([]string, 50000)
+	b := make([]string, 50000)
+
+	for i := range a {
+		a[i] = string(rune(i))
+		b[i] = string(rune(i + 60000))
+	}
+
+	inserted, deleted := Stat(Lines(a, b))
+	if inserted != len(b) || deleted != len(a) {
+		t.Errorf("expected full replacement, got +%d -%d", inserted, deleted)
+	}
+}
+
+func TestPatch(t *testing.T) {
+	a := SplitLines("1\n2\n3\n4\n5\n6\n7\n8\n")
+	b := SplitLines("1\nTWO\n3\n4\n5\n6\nSEVEN\n8\nnine\n")
+
+	for _, context := range []int{0, 1, 3} {
+		hunks := Hunks(Lines(a, b), context)
+
+		all := Patch(a, hunks, func(int, Hunk) bool { return true })
+		if got := strings.Join(all, ""); got != strings.Join(b, "") {
+			t.Errorf("context %d: accepting every hunk gave %q", context, got)
+		}
+
+		none := Patch(a, hunks, func(int, Hunk) bool { return false })
+		if got := strings.Join(none, ""); got != strings.Join(a, "") {
+			t.Errorf("context %d: rejecting every hunk gave %q", context, got)
+		}
+	}
+
+	hunks := Hunks(Lines(a, b), 0)
+	if len(hunks) != 3 {
+		t.Fatalf("expected 3 hunks, got %d", len(hunks))
+	}
+
+	got := Patch(a, hunks, func(i int, _ Hunk) bool { return i != 1 })
+	if expected := "1\nTWO\n3\n4\n5\n6\n7\n8\nnine\n"; strings.Join(got, "") != expected {
+		t.Errorf("expected %q, got %q", expected, strings.Join(got, ""))
+	}
+}
@@ -0,0 +1,99 @@
+package executor
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/Gx2-Studio/ssed/pkg/ast"
+)
+
+func toggleComment(line, marker string) string {
+	marker = strings.TrimRight(marker, " ")
+
+	indent, rest := splitIndent(line)
+	if rest == "" || marker == "" {
+		return line
+	}
+
+	if strings.HasPrefix(rest, marker) {
+		return indent + strings.TrimPrefix(rest[len(marker):], " ")
+	}
+
+	return indent + marker + " " + rest
+}
+
+func executeAffix(cmd *ast.AffixCommand, scanner lineSource, lw lineSink) error {
+	var re *regexp.Regexp
+
+	if cmd.IsRegex {
+		var err error
+
+		re, err = regexp.Compile(cmd.Target)
+		if err != nil {
+			return err
+		}
+	}
+
+	var wholeWordRe *regexp.Regexp
+	if cmd.WholeWord && !cmd.IsRegex && cmd.Target != "" {
+		wholeWordRe = regexp.MustCompile(`\b` + regexp.QuoteMeta(cmd.Target) + `\b`)
+	}
+
+	var matchRe *regexp.Regexp
+
+	if cmd.Position == ast.AffixSurround && cmd.MatchIsRegex {
+		var err error
+
+		matchRe, err = regexp.Compile(cmd.Match)
+		if err != nil {
+			return err
+		}
+	}
+
+	lineNum := 0
+
+	for scanner.Scan() {
+		lineNum++
+		line := scanner.Text()
+
+		selected := true
+
+		if cmd.LineRange != nil {
+			selected = cmd.LineRange.Contains(lineNum)
+		} else if cmd.Target != "" {
+			selected = matchPattern(line, cmd.Target, cmd.IsRegex, cmd.PatternType, cmd.WholeWord, re, wholeWordRe)
+			if cmd.Negated {
+				selected = !selected
+			}
+		}
+
+		if selected {
+			switch cmd.Position {
+			case ast.AffixPrefix:
+				line = cmd.Text + line
+			case ast.AffixSuffix:
+				line += cmd.Text
+			case ast.AffixSurround:
+				if matchRe != nil {
+					line = matchRe.ReplaceAllStringFunc(line, func(m string) string {
+						return cmd.Text + m + cmd.ClosingText
+					})
+				} else if cmd.Match != "" {
+					line = strings.ReplaceAll(line, cmd.Match, cmd.Text+cmd.Match+cmd.ClosingText)
+				}
+			case ast.AffixToggleComment:
+				line = toggleComment(line, cmd.Text)
+			}
+		}
+
+		if err := lw.writeLine(line); err != nil {
+			return err
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	return lw.flush()
+}
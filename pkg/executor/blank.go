@@ -0,0 +1,258 @@
+package executor
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/Gx2-Studio/ssed/pkg/ast"
+)
+
+// maxBlankRuns bounds what "delete trailing blank lines" holds back in
+// memory: runs of identical, consecutive blank lines are stored as one, and
+// past this many different ones they move to a temporary file.
+const maxBlankRuns = 1024
+
+type blankRun struct {
+	first record
+	count int
+}
+
+type heldBlanks struct {
+	runs    []blankRun
+	spill   *os.File
+	w       *bufio.Writer
+	spilled bool
+}
+
+func (h *heldBlanks) add(r record) error {
+	last := len(h.runs) - 1
+	if last >= 0 && h.runs[last].extends(r) {
+		h.runs[last].count++
+
+		return nil
+	}
+
+	if len(h.runs) == maxBlankRuns {
+		if err := h.spillRuns(); err != nil {
+			return err
+		}
+	}
+
+	h.runs = append(h.runs, blankRun{first: r, count: 1})
+
+	return nil
+}
+
+// spillRuns appends the runs to the temporary file as count, line number,
+// then length and bytes of the text and of the terminator.
+func (h *heldBlanks) spillRuns() error {
+	if h.spill == nil {
+		f, err := os.CreateTemp("", "ssed-blank-*")
+		if err != nil {
+			return fmt.Errorf("error holding back blank lines: %w", err)
+		}
+
+		h.spill = f
+		h.w = bufio.NewWriter(f)
+	}
+
+	var buf []byte
+
+	for _, run := range h.runs {
+		buf = binary.AppendUvarint(buf[:0], uint64(run.count))
+		buf = binary.AppendUvarint(buf, uint64(run.first.line))
+		buf = binary.AppendUvarint(buf, uint64(len(run.first.text)))
+		buf = append(buf, run.first.text...)
+		buf = binary.AppendUvarint(buf, uint64(len(run.first.end)))
+		buf = append(buf, run.first.end...)
+
+		h.w.Write(buf)
+	}
+
+	h.runs = h.runs[:0]
+	h.spilled = true
+
+	return nil
+}
+
+// release writes the held lines, oldest first, and forgets them.
+func (h *heldBlanks) release(lw lineSink) error {
+	if h.spilled {
+		if err := h.replay(lw); err != nil {
+			return err
+		}
+	}
+
+	for _, run := range h.runs {
+		if err := run.write(lw); err != nil {
+			return err
+		}
+	}
+
+	h.runs = h.runs[:0]
+
+	return nil
+}
+
+func (h *heldBlanks) replay(lw lineSink) error {
+	if err := h.w.Flush(); err != nil {
+		return fmt.Errorf("error holding back blank lines: %w", err)
+	}
+
+	if _, err := h.spill.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	r := bufio.NewReader(h.spill)
+
+	for {
+		count, err := binary.ReadUvarint(r)
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return err
+		}
+
+		line, err := binary.ReadUvarint(r)
+		if err != nil {
+			return err
+		}
+
+		text, err := readSpilled(r)
+		if err != nil {
+			return err
+		}
+
+		end, err := readSpilled(r)
+		if err != nil {
+			return err
+		}
+
+		run := blankRun{first: record{text: text, line: int(line), end: end}, count: int(count)}
+		if err := run.write(lw); err != nil {
+			return err
+		}
+	}
+
+	if err := h.spill.Truncate(0); err != nil {
+		return err
+	}
+
+	if _, err := h.spill.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	h.w.Reset(h.spill)
+	h.spilled = false
+
+	return nil
+}
+
+func readSpilled(r *bufio.Reader) (string, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return "", err
+	}
+
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return "", err
+	}
+
+	return string(b), nil
+}
+
+// extends reports whether r continues the run: the same blank line, read
+// with the same terminator, right after it.
+func (run blankRun) extends(r record) bool {
+	return run.first.text == r.text && run.first.end == r.end && run.first.line+run.count == r.line
+}
+
+func (run blankRun) write(lw lineSink) error {
+	r := run.first
+
+	for i := 0; i < run.count; i++ {
+		if err := lw.writeRecord(r); err != nil {
+			return err
+		}
+
+		r.line++
+	}
+
+	return nil
+}
+
+func (h *heldBlanks) close() {
+	if h.spill != nil {
+		h.spill.Close()
+		os.Remove(h.spill.Name())
+	}
+}
+
+func isBlank(line string) bool {
+	return strings.TrimSpace(line) == ""
+}
+
+func executeBlankLines(cmd *ast.BlankLinesCommand, scanner lineSource, lw lineSink) error {
+	var held heldBlanks
+	defer held.close()
+
+	seenText := false
+	prevBlank := false
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		switch cmd.Type {
+		case ast.BlankDeleteEmpty:
+			if line == "" {
+				continue
+			}
+		case ast.BlankDeleteBlank:
+			if isBlank(line) {
+				continue
+			}
+		case ast.BlankSqueeze:
+			blank := isBlank(line)
+			if blank && prevBlank {
+				continue
+			}
+
+			prevBlank = blank
+		case ast.BlankDeleteLeading:
+			if !seenText && isBlank(line) {
+				continue
+			}
+
+			seenText = true
+		case ast.BlankDeleteTrailing:
+			if isBlank(line) {
+				if err := held.add(current(scanner)); err != nil {
+					return err
+				}
+
+				continue
+			}
+
+			if err := held.release(lw); err != nil {
+				return err
+			}
+		}
+
+		if err := lw.writeLine(line); err != nil {
+			return err
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	return lw.flush()
+}
@@ -0,0 +1,449 @@
+package executor
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/Gx2-Studio/ssed/pkg/ast"
+)
+
+// Encoding is the character encoding of the input and output. Commands always
+// see UTF-8; everything else is decoded on the way in and re-encoded on the
+// way out.
+type Encoding int
+
+const (
+	EncodingAuto Encoding = iota
+	EncodingUTF8
+	EncodingUTF16LE
+	EncodingUTF16BE
+	EncodingLatin1
+	EncodingWindows1252
+)
+
+var encodingNames = map[string]Encoding{
+	"auto":         EncodingAuto,
+	"utf-8":        EncodingUTF8,
+	"utf8":         EncodingUTF8,
+	"utf-16le":     EncodingUTF16LE,
+	"utf16le":      EncodingUTF16LE,
+	"utf-16be":     EncodingUTF16BE,
+	"utf16be":      EncodingUTF16BE,
+	"latin1":       EncodingLatin1,
+	"latin-1":      EncodingLatin1,
+	"iso-8859-1":   EncodingLatin1,
+	"iso8859-1":    EncodingLatin1,
+	"windows-1252": EncodingWindows1252,
+	"cp1252":       EncodingWindows1252,
+}
+
+func ParseEncoding(name string) (Encoding, error) {
+	if name == "" {
+		return EncodingAuto, nil
+	}
+
+	if enc, ok := encodingNames[strings.ToLower(name)]; ok {
+		return enc, nil
+	}
+
+	return EncodingAuto, fmt.Errorf("unknown encoding %q (expected utf-8, utf-16le, utf-16be, latin1, or windows-1252)", name)
+}
+
+func (e Encoding) String() string {
+	switch e {
+	case EncodingUTF8:
+		return "utf-8"
+	case EncodingUTF16LE:
+		return "utf-16le"
+	case EncodingUTF16BE:
+		return "utf-16be"
+	case EncodingLatin1:
+		return "latin1"
+	case EncodingWindows1252:
+		return "windows-1252"
+	default:
+		return "auto"
+	}
+}
+
+var boms = map[Encoding][]byte{
+	EncodingUTF8:    {0xEF, 0xBB, 0xBF},
+	EncodingUTF16LE: {0xFF, 0xFE},
+	EncodingUTF16BE: {0xFE, 0xFF},
+}
+
+// windows1252High maps 0x80-0x9F, the only range where Windows-1252 differs
+// from Latin-1. Unassigned bytes map to themselves like in Latin-1.
+var windows1252High = [32]rune{
+	'€', 0x81, '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', 'Š', '‹', 'Œ', 0x8D, 'Ž', 0x8F,
+	0x90, '‘', '’', '“', '”', '•', '–', '—', '˜', '™', 'š', '›', 'œ', 0x9D, 'ž', 'Ÿ',
+}
+
+// detectBOM strips a byte order mark matching enc (any BOM for
+// EncodingAuto) and reports the encoding it implies.
+func detectBOM(br *bufio.Reader, enc Encoding) (Encoding, bool) {
+	head, _ := br.Peek(3)
+
+	for _, candidate := range []Encoding{EncodingUTF8, EncodingUTF16LE, EncodingUTF16BE} {
+		if enc != EncodingAuto && enc != candidate {
+			continue
+		}
+
+		if bytes.HasPrefix(head, boms[candidate]) {
+			_, _ = br.Discard(len(boms[candidate]))
+
+			return candidate, true
+		}
+	}
+
+	if enc == EncodingAuto {
+		return EncodingUTF8, false
+	}
+
+	return enc, false
+}
+
+// DecodeInput returns a UTF-8 view of input along with the encoding it was
+// read in and whether it started with a BOM. This is what ExecuteWithOptions
+// runs the commands on.
+func DecodeInput(input io.Reader, enc Encoding) (io.Reader, Encoding, bool) {
+	br := bufio.NewReaderSize(input, 64*1024)
+	enc, bom := detectBOM(br, enc)
+
+	switch enc {
+	case EncodingUTF16LE, EncodingUTF16BE:
+		return &utf16Reader{r: br, bigEndian: enc == EncodingUTF16BE}, enc, bom
+	case EncodingLatin1, EncodingWindows1252:
+		return &singleByteReader{r: br, cp1252: enc == EncodingWindows1252}, enc, bom
+	default:
+		return br, enc, bom
+	}
+}
+
+type utf16Reader struct {
+	r         *bufio.Reader
+	bigEndian bool
+	pending   []byte
+	err       error
+}
+
+func (u *utf16Reader) unit() (uint16, bool) {
+	var b [2]byte
+
+	n, err := io.ReadFull(u.r, b[:])
+	if err != nil {
+		if n == 1 {
+			u.pending = utf8.AppendRune(u.pending, utf8.RuneError)
+		}
+
+		if err == io.ErrUnexpectedEOF {
+			err = io.EOF
+		}
+
+		u.err = err
+
+		return 0, false
+	}
+
+	if u.bigEndian {
+		return uint16(b[0])<<8 | uint16(b[1]), true
+	}
+
+	return uint16(b[1])<<8 | uint16(b[0]), true
+}
+
+func (u *utf16Reader) Read(p []byte) (int, error) {
+	for len(u.pending) < len(p) && u.err == nil {
+		c, ok := u.unit()
+		if !ok {
+			break
+		}
+
+		r := rune(c)
+
+		if utf16.IsSurrogate(r) {
+			low, ok := u.unit()
+			if !ok {
+				r = utf8.RuneError
+			} else {
+				r = utf16.DecodeRune(r, rune(low))
+			}
+		}
+
+		u.pending = utf8.AppendRune(u.pending, r)
+	}
+
+	if len(u.pending) == 0 {
+		return 0, u.err
+	}
+
+	n := copy(p, u.pending)
+	u.pending = u.pending[:copy(u.pending, u.pending[n:])]
+
+	return n, nil
+}
+
+type singleByteReader struct {
+	r       *bufio.Reader
+	cp1252  bool
+	pending []byte
+}
+
+func (s *singleByteReader) Read(p []byte) (int, error) {
+	for len(s.pending) < len(p) {
+		b, err := s.r.ReadByte()
+		if err != nil {
+			if len(s.pending) == 0 {
+				return 0, err
+			}
+
+			break
+		}
+
+		r := rune(b)
+		if s.cp1252 && b >= 0x80 && b <= 0x9F {
+			r = windows1252High[b-0x80]
+		}
+
+		s.pending = utf8.AppendRune(s.pending, r)
+	}
+
+	n := copy(p, s.pending)
+	s.pending = s.pending[:copy(s.pending, s.pending[n:])]
+
+	return n, nil
+}
+
+// encodeWriter converts the UTF-8 written to it into enc, writing the BOM
+// before the first byte of output.
+type encodeWriter struct {
+	w       io.Writer
+	enc     Encoding
+	bom     bool
+	partial []byte
+	buf     []byte
+}
+
+func newEncodeWriter(w io.Writer, enc Encoding, bom bool) *encodeWriter {
+	return &encodeWriter{w: w, enc: enc, bom: bom}
+}
+
+func (e *encodeWriter) writeBOM() error {
+	if !e.bom {
+		return nil
+	}
+
+	e.bom = false
+
+	_, err := e.w.Write(boms[e.enc])
+
+	return err
+}
+
+func (e *encodeWriter) encodeRune(r rune) error {
+	switch e.enc {
+	case EncodingUTF16LE, EncodingUTF16BE:
+		units := []uint16{uint16(r)}
+		if r >= 0x10000 {
+			r1, r2 := utf16.EncodeRune(r)
+			units = []uint16{uint16(r1), uint16(r2)}
+		}
+
+		for _, u := range units {
+			if e.enc == EncodingUTF16BE {
+				e.buf = append(e.buf, byte(u>>8), byte(u))
+			} else {
+				e.buf = append(e.buf, byte(u), byte(u>>8))
+			}
+		}
+	case EncodingLatin1:
+		if r > 0xFF {
+			return fmt.Errorf("cannot encode %q as %s", r, e.enc)
+		}
+
+		e.buf = append(e.buf, byte(r))
+	case EncodingWindows1252:
+		if r < 0x80 || (r > 0x9F && r <= 0xFF) {
+			e.buf = append(e.buf, byte(r))
+
+			return nil
+		}
+
+		for i, c := range windows1252High {
+			if c == r {
+				e.buf = append(e.buf, byte(0x80+i))
+
+				return nil
+			}
+		}
+
+		return fmt.Errorf("cannot encode %q as %s", r, e.enc)
+	default:
+		e.buf = utf8.AppendRune(e.buf, r)
+	}
+
+	return nil
+}
+
+func (e *encodeWriter) Write(p []byte) (int, error) {
+	if err := e.writeBOM(); err != nil {
+		return 0, err
+	}
+
+	data := p
+	if len(e.partial) > 0 {
+		data = append(e.partial, p...)
+		e.partial = nil
+	}
+
+	if e.enc == EncodingUTF8 {
+		if _, err := e.w.Write(data); err != nil {
+			return 0, err
+		}
+
+		return len(p), nil
+	}
+
+	e.buf = e.buf[:0]
+
+	for len(data) > 0 {
+		if !utf8.FullRune(data) {
+			e.partial = append(e.partial, data...)
+
+			break
+		}
+
+		r, size := utf8.DecodeRune(data)
+		if err := e.encodeRune(r); err != nil {
+			return 0, err
+		}
+
+		data = data[size:]
+	}
+
+	if _, err := e.w.Write(e.buf); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// Close writes the BOM of an otherwise empty output and any incomplete
+// trailing sequence as a replacement character.
+func (e *encodeWriter) Close() error {
+	if err := e.writeBOM(); err != nil {
+		return err
+	}
+
+	if len(e.partial) == 0 {
+		return nil
+	}
+
+	e.partial = nil
+	e.buf = e.buf[:0]
+
+	if err := e.encodeRune(utf8.RuneError); err != nil {
+		return err
+	}
+
+	_, err := e.w.Write(e.buf)
+
+	return err
+}
+
+// OutputEncoding returns the encoding ExecuteWithOptions writes the output of
+// cmd in, and whether with a BOM, for input read in enc: the one a "convert"
+// command asks for, or else the input's own. Counts are plain UTF-8.
+func OutputEncoding(cmd ast.Command, enc Encoding, bom bool) (Encoding, bool, error) {
+	if _, isCount := lastCommand(cmd).(*ast.CountCommand); isCount {
+		return EncodingUTF8, false, nil
+	}
+
+	requested, ok, err := requestedEncoding(cmd)
+	if err != nil {
+		return enc, bom, err
+	}
+
+	if ok {
+		return requested, requested == EncodingUTF16LE || requested == EncodingUTF16BE, nil
+	}
+
+	return enc, bom, nil
+}
+
+// EncodeText converts UTF-8 text into enc, as ExecuteWithOptions encodes its
+// output.
+func EncodeText(text string, enc Encoding, bom bool) (string, error) {
+	var b strings.Builder
+
+	ew := newEncodeWriter(&b, enc, bom)
+	if _, err := io.WriteString(ew, text); err != nil {
+		return "", err
+	}
+
+	if err := ew.Close(); err != nil {
+		return "", err
+	}
+
+	return b.String(), nil
+}
+
+func requestedEncoding(cmd ast.Command) (Encoding, bool, error) {
+	switch command := cmd.(type) {
+	case *ast.EncodingCommand:
+		enc, err := ParseEncoding(command.Name)
+		if err == nil && enc == EncodingAuto {
+			err = fmt.Errorf("cannot convert encoding to %q", command.Name)
+		}
+
+		return enc, true, err
+	case *ast.CompoundCommand:
+		for i := len(command.Commands) - 1; i >= 0; i-- {
+			if enc, ok, err := requestedEncoding(command.Commands[i]); ok {
+				return enc, true, err
+			}
+		}
+	}
+
+	return EncodingAuto, false, nil
+}
+
+// LooksBinary reports whether head, the first block of a file, looks like
+// binary data rather than text in enc: a NUL byte, or invalid UTF-8 when the
+// encoding is UTF-8 or unknown.
+func LooksBinary(head []byte, enc Encoding) bool {
+	if enc == EncodingAuto {
+		if bytes.HasPrefix(head, boms[EncodingUTF16LE]) || bytes.HasPrefix(head, boms[EncodingUTF16BE]) {
+			return false
+		}
+	}
+
+	switch enc {
+	case EncodingUTF16LE, EncodingUTF16BE:
+		return false
+	case EncodingLatin1, EncodingWindows1252:
+		return bytes.IndexByte(head, 0) >= 0
+	}
+
+	if bytes.IndexByte(head, 0) >= 0 {
+		return true
+	}
+
+	// The block may end in the middle of a character.
+	for i := 1; i < utf8.UTFMax && i <= len(head); i++ {
+		if utf8.RuneStart(head[len(head)-i]) {
+			if !utf8.FullRune(head[len(head)-i:]) {
+				head = head[:len(head)-i]
+			}
+
+			break
+		}
+	}
+
+	return !utf8.Valid(head)
+}
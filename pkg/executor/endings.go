@@ -0,0 +1,176 @@
+package executor
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/Gx2-Studio/ssed/pkg/ast"
+)
+
+type LineEnding int
+
+const (
+	LineEndingKeep LineEnding = iota
+	LineEndingLF
+	LineEndingCRLF
+)
+
+type Options struct {
+	LineEnding LineEnding
+	// Encoding is the input encoding; a BOM is detected when it is
+	// EncodingAuto. Output is written back in the same encoding.
+	Encoding Encoding
+	// RecordSeparator splits the input on this string instead of newlines.
+	RecordSeparator string
+	// RecordRegexp splits the input on every match. A pattern starting with
+	// "^" instead starts a new record at each line it matches.
+	RecordRegexp *regexp.Regexp
+	// OnMatch, if set, is called for every record selected by show or
+	// counted by count when it is the last command of the query.
+	OnMatch func(Match)
+	// Format, if set, turns every record selected by the last command of
+	// the query, if it is show, into the record written for it, e.g. to
+	// prefix it with its line number.
+	Format func(Match) string
+}
+
+func detectCRLF(br *bufio.Reader) bool {
+	n := 1
+
+	for {
+		buf, err := br.Peek(n)
+
+		if i := bytes.IndexByte(buf, '\n'); i >= 0 {
+			return i > 0 && buf[i-1] == '\r'
+		}
+
+		if err != nil || n >= br.Size() {
+			return false
+		}
+
+		n = min(br.Buffered()+1, br.Size())
+	}
+}
+
+func lastCommand(cmd ast.Command) ast.Command {
+	if compound, ok := cmd.(*ast.CompoundCommand); ok && len(compound.Commands) > 0 {
+		return lastCommand(compound.Commands[len(compound.Commands)-1])
+	}
+
+	return cmd
+}
+
+func requestedLineEnding(cmd ast.Command) (LineEnding, bool) {
+	switch command := cmd.(type) {
+	case *ast.LineEndingCommand:
+		if command.Style == ast.LineEndingCRLF {
+			return LineEndingCRLF, true
+		}
+
+		return LineEndingLF, true
+	case *ast.CompoundCommand:
+		for i := len(command.Commands) - 1; i >= 0; i-- {
+			if ending, ok := requestedLineEnding(command.Commands[i]); ok {
+				return ending, true
+			}
+		}
+	}
+
+	return LineEndingKeep, false
+}
+
+// ExecuteWithOptions runs cmd like Execute, but preserves the input's
+// encoding, line ending style and missing final newline unless opts or a
+// "convert" command ask for something else. With a record separator set,
+// every command works on records instead of lines and each output record is
+// followed by the separator it was read with.
+func ExecuteWithOptions(cmd ast.Command, input io.Reader, output io.Writer, opts Options) error {
+	decoded, enc, bom := DecodeInput(input, opts.Encoding)
+
+	enc, bom, err := OutputEncoding(cmd, enc, bom)
+	if err != nil {
+		return err
+	}
+
+	ew := newEncodeWriter(output, enc, bom)
+
+	if err := ExecuteText(cmd, decoded, ew, opts); err != nil {
+		return err
+	}
+
+	return ew.Close()
+}
+
+// ExecuteText runs cmd like ExecuteWithOptions on input that is already
+// UTF-8 text and writes UTF-8 text, leaving the encoding to the caller; see
+// DecodeInput, OutputEncoding and EncodeText. opts.Encoding is not used.
+func ExecuteText(cmd ast.Command, input io.Reader, output io.Writer, opts Options) error {
+	if opts.RecordRegexp != nil && opts.RecordRegexp.MatchString("") {
+		return fmt.Errorf("record separator %q matches the empty string", opts.RecordRegexp)
+	}
+
+	br := bufio.NewReaderSize(input, 64*1024)
+	lw := newLineWriter(output)
+	lw.onMatch = opts.OnMatch
+	lw.format = opts.Format
+
+	var src lineSource
+
+	switch {
+	case opts.RecordRegexp != nil && strings.HasPrefix(opts.RecordRegexp.String(), "^"):
+		src = newRecordStartScanner(br, opts.RecordRegexp)
+	case opts.RecordRegexp != nil || len(opts.RecordSeparator) > 1:
+		ss := newSplitScanner(br, opts.RecordSeparator, opts.RecordRegexp)
+		lw.sep = ss.separator()
+		src = ss
+	case len(opts.RecordSeparator) == 1 && opts.RecordSeparator != "\n":
+		src = newDelimScanner(br, opts.RecordSeparator[0])
+		lw.sep = opts.RecordSeparator
+	default:
+		src = newScanner(br)
+	}
+
+	// sep is what follows records without a terminator of their own, such
+	// as a final unterminated record that is no longer last.
+	if lw.sep == "\n" {
+		lw.ending = opts.LineEnding
+		if requested, ok := requestedLineEnding(cmd); ok {
+			lw.ending = requested
+		}
+
+		if lw.ending == LineEndingCRLF || lw.ending == LineEndingKeep && detectCRLF(br) {
+			lw.sep = "\r\n"
+		}
+	}
+
+	if _, isCount := lastCommand(cmd).(*ast.CountCommand); isCount {
+		cw := newLineWriter(output)
+		cw.onMatch = opts.OnMatch
+
+		return execute(cmd, src, cw)
+	}
+
+	lw.src = src
+
+	return execute(cmd, src, lw)
+}
+
+// executePassThrough runs commands that only change how the output is
+// written, such as line endings and encoding.
+func executePassThrough(scanner lineSource, lw lineSink) error {
+	for scanner.Scan() {
+		if err := lw.writeLine(scanner.Text()); err != nil {
+			return err
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	return lw.flush()
+}
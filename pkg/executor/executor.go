@@ -1,37 +1,29 @@
 package executor
 
 import (
-	"bufio"
+	"errors"
 	"fmt"
 	"io"
 	"regexp"
+	"strconv"
 	"strings"
 	"unicode"
 
 	"github.com/Gx2-Studio/ssed/pkg/ast"
 )
 
-const maxScanTokenSize = 10 * 1024 * 1024
-
-func newScanner(input io.Reader) *bufio.Scanner {
-	scanner := bufio.NewScanner(input)
-	scanner.Buffer(make([]byte, 64*1024), maxScanTokenSize)
-
-	return scanner
-}
-
 type ringBuffer struct {
-	data  []string
+	data  []record
 	head  int
 	count int
 }
 
 func newRingBuffer(capacity int) *ringBuffer {
-	return &ringBuffer{data: make([]string, capacity)}
+	return &ringBuffer{data: make([]record, capacity)}
 }
 
-func (r *ringBuffer) push(line string) (string, bool) {
-	var evicted string
+func (r *ringBuffer) push(line record) (record, bool) {
+	var evicted record
 
 	var hasEvicted bool
 
@@ -48,8 +40,8 @@ func (r *ringBuffer) push(line string) (string, bool) {
 	return evicted, hasEvicted
 }
 
-func (r *ringBuffer) lines() []string {
-	result := make([]string, r.count)
+func (r *ringBuffer) lines() []record {
+	result := make([]record, r.count)
 	start := (r.head - r.count + len(r.data)) % len(r.data)
 
 	for i := 0; i < r.count; i++ {
@@ -59,87 +51,86 @@ func (r *ringBuffer) lines() []string {
 	return result
 }
 
-// lineWriter provides buffered output & line+"\n" string concat overhead
-type lineWriter struct {
-	bw *bufio.Writer
-}
-
-func newLineWriter(output io.Writer) *lineWriter {
-	return &lineWriter{bw: bufio.NewWriterSize(output, 64*1024)}
-}
-
-func (w *lineWriter) writeLine(line string) error {
-	if _, err := w.bw.WriteString(line); err != nil {
-		return err
-	}
-
-	return w.bw.WriteByte('\n')
-}
-
-func (w *lineWriter) flush() error {
-	return w.bw.Flush()
+func Execute(cmd ast.Command, input io.Reader, output io.Writer) error {
+	return execute(cmd, newScanner(input), newLineWriter(output))
 }
 
-func Execute(cmd ast.Command, input io.Reader, output io.Writer) error {
+func execute(cmd ast.Command, scanner lineSource, lw lineSink) error {
 	switch command := cmd.(type) {
 	case *ast.ReplaceCommand:
-		return executeReplace(command, input, output)
+		return executeReplace(command, scanner, lw)
 	case *ast.DeleteCommand:
-		return executeDelete(command, input, output)
+		return executeDelete(command, scanner, lw)
 	case *ast.ShowCommand:
-		return executeShow(command, input, output)
+		return executeShow(command, scanner, lw)
 	case *ast.InsertCommand:
-		return executeInsert(command, input, output)
+		return executeInsert(command, scanner, lw)
 	case *ast.TransformCommand:
-		return executeTransform(command, input, output)
+		return executeTransform(command, scanner, lw)
 	case *ast.CountCommand:
-		return executeCount(command, input, output)
+		return executeCount(command, scanner, lw)
+	case *ast.LayoutCommand:
+		return executeLayout(command, scanner, lw)
+	case *ast.AffixCommand:
+		return executeAffix(command, scanner, lw)
+	case *ast.RemoveCommand:
+		return executeRemove(command, scanner, lw)
+	case *ast.BlankLinesCommand:
+		return executeBlankLines(command, scanner, lw)
+	case *ast.LineEndingCommand, *ast.EncodingCommand:
+		return executePassThrough(scanner, lw)
 	case *ast.CompoundCommand:
-		return executeCompound(command, input, output)
+		return executeCompound(command, scanner, lw)
 	default:
 		return nil
 	}
 }
 
-func executeCompound(cmd *ast.CompoundCommand, input io.Reader, output io.Writer) error {
+func executeCompound(cmd *ast.CompoundCommand, scanner lineSource, lw lineSink) error {
 	if len(cmd.Commands) == 0 {
 		return nil
 	}
 
 	if len(cmd.Commands) == 1 {
-		return Execute(cmd.Commands[0], input, output)
+		return execute(cmd.Commands[0], scanner, lw)
 	}
 
 	numPipes := len(cmd.Commands) - 1
-	pipes := make([]*io.PipeWriter, numPipes)
-	readers := make([]*io.PipeReader, numPipes)
+	pipes := make([]*recordPipe, numPipes)
 
 	for i := 0; i < numPipes; i++ {
-		readers[i], pipes[i] = io.Pipe()
+		if i == 0 {
+			pipes[i] = newRecordPipe(scanner)
+		} else {
+			pipes[i] = newRecordPipe(pipes[i-1])
+		}
 	}
 
-	errChan := make(chan error, len(cmd.Commands))
+	errChan := make(chan error, numPipes)
 
 	for i := 0; i < numPipes; i++ {
 		go func(idx int) {
-			var cmdInput io.Reader
-			if idx == 0 {
-				cmdInput = input
-			} else {
-				cmdInput = readers[idx-1]
+			err := execute(cmd.Commands[idx], pipes[idx].src, pipes[idx])
+			pipes[idx].closeWithError(err)
+
+			if idx > 0 {
+				pipes[idx-1].stop()
 			}
 
-			err := Execute(cmd.Commands[idx], cmdInput, pipes[idx])
-			pipes[idx].CloseWithError(err)
 			errChan <- err
 		}(i)
 	}
 
-	lastInput := readers[numPipes-1]
-	lastErr := Execute(cmd.Commands[numPipes], lastInput, output)
+	// The records the last command writes stem from the last pipe.
+	if w, ok := lw.(*lineWriter); ok && w.src != nil {
+		w.src = pipes[numPipes-1]
+	}
+
+	lastErr := execute(cmd.Commands[numPipes], pipes[numPipes-1], lw)
+	pipes[numPipes-1].stop()
 
 	for i := 0; i < numPipes; i++ {
-		if err := <-errChan; err != nil {
+		if err := <-errChan; err != nil && !errors.Is(err, errPipeStopped) {
 			return err
 		}
 	}
@@ -147,10 +138,7 @@ func executeCompound(cmd *ast.CompoundCommand, input io.Reader, output io.Writer
 	return lastErr
 }
 
-func executeReplace(cmd *ast.ReplaceCommand, input io.Reader, output io.Writer) error {
-	scanner := newScanner(input)
-	lw := newLineWriter(output)
-
+func executeReplace(cmd *ast.ReplaceCommand, scanner lineSource, lw lineSink) error {
 	var re *regexp.Regexp
 
 	if cmd.IsRegex {
@@ -183,16 +171,13 @@ func executeReplace(cmd *ast.ReplaceCommand, input io.Reader, output io.Writer)
 	return lw.flush()
 }
 
-func executeDelete(cmd *ast.DeleteCommand, input io.Reader, output io.Writer) error {
-	scanner := newScanner(input)
-	lw := newLineWriter(output)
-
+func executeDelete(cmd *ast.DeleteCommand, scanner lineSource, lw lineSink) error {
 	if cmd.LastN > 0 {
 		ring := newRingBuffer(cmd.LastN)
 
 		for scanner.Scan() {
-			if evicted, ok := ring.push(scanner.Text()); ok {
-				if err := lw.writeLine(evicted); err != nil {
+			if evicted, ok := ring.push(current(scanner)); ok {
+				if err := lw.writeRecord(evicted); err != nil {
 					return err
 				}
 			}
@@ -264,23 +249,31 @@ func executeDelete(cmd *ast.DeleteCommand, input io.Reader, output io.Writer) er
 	return lw.flush()
 }
 
-func executeShow(cmd *ast.ShowCommand, input io.Reader, output io.Writer) error {
-	scanner := newScanner(input)
-	lw := newLineWriter(output)
+func executeShow(cmd *ast.ShowCommand, scanner lineSource, lw lineSink) error {
+	// "with line numbers" numbers the selected records like cat -n.
+	show := lw.writeMatch
+	if cmd.ShowLineNumbers {
+		show = func(r record, spans []Span) error {
+			lw.reportMatch(Match{Line: r.line, Text: r.text, Spans: spans})
+			r.text = fmt.Sprintf("%6d\t%s", r.line, r.text)
+
+			return lw.writeRecord(r)
+		}
+	}
 
 	if cmd.LastN > 0 {
 		ring := newRingBuffer(cmd.LastN)
 
 		for scanner.Scan() {
-			ring.push(scanner.Text())
+			ring.push(current(scanner))
 		}
 
 		if err := scanner.Err(); err != nil {
 			return err
 		}
 
-		for _, line := range ring.lines() {
-			if err := lw.writeLine(line); err != nil {
+		for _, r := range ring.lines() {
+			if err := show(r, nil); err != nil {
 				return err
 			}
 		}
@@ -310,17 +303,9 @@ func executeShow(cmd *ast.ShowCommand, input io.Reader, output io.Writer) error
 		lineNum++
 		line := scanner.Text()
 
-		if cmd.ShowLineNumbers {
-			if _, err := fmt.Fprintf(lw.bw, "%6d\t%s\n", lineNum, line); err != nil {
-				return err
-			}
-
-			continue
-		}
-
 		if cmd.FirstN > 0 {
 			if lineNum <= cmd.FirstN {
-				if err := lw.writeLine(line); err != nil {
+				if err := show(current(scanner), nil); err != nil {
 					return err
 				}
 			}
@@ -353,7 +338,12 @@ func executeShow(cmd *ast.ShowCommand, input io.Reader, output io.Writer) error
 			}
 		}
 
-		if err := lw.writeLine(line); err != nil {
+		var spans []Span
+		if cmd.LineRange == nil && cmd.Target != "" && !cmd.Negated && lw.reportsMatches() {
+			spans = matchSpans(line, cmd.Target, cmd.IsRegex, cmd.PatternType, cmd.WholeWord, re, wholeWordRe)
+		}
+
+		if err := show(current(scanner), spans); err != nil {
 			return err
 		}
 	}
@@ -398,46 +388,110 @@ func matchPattern(
 	}
 }
 
-func executeInsert(cmd *ast.InsertCommand, input io.Reader, output io.Writer) error {
-	scanner := newScanner(input)
-	lw := newLineWriter(output)
+// matchSpans returns where the pattern matchPattern accepted occurs in line.
+func matchSpans(
+	line, target string,
+	isRegex bool,
+	patternType ast.PatternType,
+	wholeWord bool,
+	re *regexp.Regexp,
+	wholeWordRe *regexp.Regexp,
+) []Span {
+	if isRegex || wholeWord {
+		if !isRegex {
+			re = wholeWordRe
+			if re == nil {
+				re = regexp.MustCompile(`\b` + regexp.QuoteMeta(target) + `\b`)
+			}
+		}
+
+		var spans []Span
+
+		for _, loc := range re.FindAllStringIndex(line, -1) {
+			spans = append(spans, Span{Start: loc[0], End: loc[1]})
+		}
+
+		return spans
+	}
+
+	switch patternType {
+	case ast.PatternStartsWith:
+		return []Span{{Start: 0, End: len(target)}}
+	case ast.PatternEndsWith:
+		return []Span{{Start: len(line) - len(target), End: len(line)}}
+	default:
+		return literalSpans(line, target)
+	}
+}
+
+// literalSpans returns the non-overlapping occurrences of target in line.
+func literalSpans(line, target string) []Span {
+	if target == "" {
+		return nil
+	}
+
+	var spans []Span
+
+	for start := 0; ; {
+		i := strings.Index(line[start:], target)
+		if i < 0 {
+			return spans
+		}
 
-	var lines []string
+		spans = append(spans, Span{Start: start + i, End: start + i + len(target)})
+		start += i + len(target)
+	}
+}
+
+func executeInsert(cmd *ast.InsertCommand, scanner lineSource, lw lineSink) error {
+	var lines []record
 
 	for scanner.Scan() {
-		lines = append(lines, scanner.Text())
+		lines = append(lines, current(scanner))
 	}
 
 	if err := scanner.Err(); err != nil {
 		return err
 	}
 
+	// The inserted line takes the number of the line it is next to.
+	insert := func(next record) error {
+		next.text = cmd.Text
+
+		return lw.writeRecord(next)
+	}
+
+	first, last := current(scanner), current(scanner)
+	if len(lines) > 0 {
+		first, last = lines[0], lines[len(lines)-1]
+	}
+
 	if cmd.Position == ast.InsertPrepend {
-		if err := lw.writeLine(cmd.Text); err != nil {
+		if err := insert(first); err != nil {
 			return err
 		}
 	}
 
-	for _, line := range lines {
-		if cmd.Position == ast.InsertBefore && strings.Contains(line, cmd.Reference) {
-			if err := lw.writeLine(cmd.Text); err != nil {
+	for _, r := range lines {
+		if cmd.Position == ast.InsertBefore && strings.Contains(r.text, cmd.Reference) {
+			if err := insert(r); err != nil {
 				return err
 			}
 		}
 
-		if err := lw.writeLine(line); err != nil {
+		if err := lw.writeRecord(r); err != nil {
 			return err
 		}
 
-		if cmd.Position == ast.InsertAfter && strings.Contains(line, cmd.Reference) {
-			if err := lw.writeLine(cmd.Text); err != nil {
+		if cmd.Position == ast.InsertAfter && strings.Contains(r.text, cmd.Reference) {
+			if err := insert(r); err != nil {
 				return err
 			}
 		}
 	}
 
 	if cmd.Position == ast.InsertAppend {
-		if err := lw.writeLine(cmd.Text); err != nil {
+		if err := insert(last); err != nil {
 			return err
 		}
 	}
@@ -445,13 +499,30 @@ func executeInsert(cmd *ast.InsertCommand, input io.Reader, output io.Writer) er
 	return lw.flush()
 }
 
-func executeTransform(cmd *ast.TransformCommand, input io.Reader, output io.Writer) error {
-	scanner := newScanner(input)
-	lw := newLineWriter(output)
+func executeTransform(cmd *ast.TransformCommand, scanner lineSource, lw lineSink) error {
+	if cmd.Type == ast.TransformDedentCommon {
+		return executeDedentCommon(scanner, lw)
+	}
+
+	amount := cmd.Amount
+	if amount <= 0 {
+		amount = defaultIndentWidth
+	}
+
+	lineNum := 0
 
 	for scanner.Scan() {
+		lineNum++
 		line := scanner.Text()
 
+		if cmd.LineRange != nil && !cmd.LineRange.Contains(lineNum) {
+			if err := lw.writeLine(line); err != nil {
+				return err
+			}
+
+			continue
+		}
+
 		switch cmd.Type {
 		case ast.TransformUppercase:
 			line = strings.ToUpper(line)
@@ -465,6 +536,14 @@ func executeTransform(cmd *ast.TransformCommand, input io.Reader, output io.Writ
 			line = strings.TrimLeftFunc(line, unicode.IsSpace)
 		case ast.TransformTrimTrailing:
 			line = strings.TrimRightFunc(line, unicode.IsSpace)
+		case ast.TransformIndent:
+			line = indentLine(line, amount, cmd.UseTabs)
+		case ast.TransformDedent:
+			line = dedentLine(line, amount)
+		case ast.TransformTabsToSpaces:
+			line = expandTabs(line, amount)
+		case ast.TransformSpacesToTabs:
+			line = unexpandLeading(line, amount)
 		}
 
 		if err := lw.writeLine(line); err != nil {
@@ -498,8 +577,7 @@ func toTitleCase(s string) string {
 	return strings.Join(words, " ")
 }
 
-func executeCount(cmd *ast.CountCommand, input io.Reader, output io.Writer) error {
-	scanner := newScanner(input)
+func executeCount(cmd *ast.CountCommand, scanner lineSource, lw lineSink) error {
 	count := 0
 
 	var re *regexp.Regexp
@@ -523,6 +601,17 @@ func executeCount(cmd *ast.CountCommand, input io.Reader, output io.Writer) erro
 			match = strings.Contains(line, cmd.Target)
 		}
 
+		if match && lw.reportsMatches() {
+			m := Match{Line: scanner.Line(), Text: line}
+			if cmd.IsRegex {
+				m.Spans = matchSpans(line, cmd.Target, true, ast.PatternContains, false, re, nil)
+			} else {
+				m.Spans = literalSpans(line, cmd.Target)
+			}
+
+			lw.reportMatch(m)
+		}
+
 		if match {
 			count++
 		}
@@ -532,7 +621,9 @@ func executeCount(cmd *ast.CountCommand, input io.Reader, output io.Writer) erro
 		return err
 	}
 
-	_, err := fmt.Fprintf(output, "%d\n", count)
+	if err := lw.writeLine(strconv.Itoa(count)); err != nil {
+		return err
+	}
 
-	return err
+	return lw.flush()
 }
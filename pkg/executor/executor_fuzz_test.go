@@ -49,6 +49,11 @@ func FuzzExecutor(f *testing.F) {
 		{"trim then replace foo with bar", "  foo  \n  bar  \n"},
 		{"show error then count error", "error1\ninfo\nerror2\n"},
 
+		// Layout
+		{"wrap lines at 10 columns", "the quick brown fox jumps over\n"},
+		{"fill paragraphs at 20", "one two\nthree\n\nfour\n"},
+		{"center lines in 20", "日本語\nhi\n"},
+
 		// Regex
 		{"replace /[0-9]+/ with NUM", "test123\n456test\n"},
 		{"delete /^#/", "# comment\ncode\n"},
@@ -2,6 +2,10 @@ package executor
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
 	"strings"
 	"testing"
 
@@ -244,28 +248,51 @@ func TestExecuteDeleteLine(t *testing.T) {
 func TestExecuteShowLineNumbers(t *testing.T) {
 	tests := []struct {
 		name     string
+		cmd      ast.Command
 		input    string
 		expected string
 	}{
 		{
 			"show all lines with numbers",
+			&ast.ShowCommand{ShowLineNumbers: true},
 			"hello\nworld\ntest\n",
 			"     1\thello\n     2\tworld\n     3\ttest\n",
 		},
 		{
 			"empty input",
+			&ast.ShowCommand{ShowLineNumbers: true},
 			"",
 			"",
 		},
+		{
+			"filtered",
+			&ast.ShowCommand{Target: "o", ShowLineNumbers: true},
+			"hello\ntest\nworld\n",
+			"     1\thello\n     3\tworld\n",
+		},
+		{
+			"last lines",
+			&ast.ShowCommand{LastN: 1, ShowLineNumbers: true},
+			"hello\nworld\ntest\n",
+			"     3\ttest\n",
+		},
+		{
+			"original numbers after a filter",
+			&ast.CompoundCommand{Commands: []ast.Command{
+				&ast.DeleteCommand{Target: "hello"},
+				&ast.ShowCommand{ShowLineNumbers: true},
+			}},
+			"hello\nworld\ntest\n",
+			"     2\tworld\n     3\ttest\n",
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			cmd := &ast.ShowCommand{ShowLineNumbers: true}
 			input := strings.NewReader(tt.input)
 			var output bytes.Buffer
 
-			err := Execute(cmd, input, &output)
+			err := Execute(tt.cmd, input, &output)
 			if err != nil {
 				t.Fatalf("unexpected error: %v", err)
 			}
@@ -873,6 +900,407 @@ func TestExecuteTransform(t *testing.T) {
 	}
 }
 
+func TestExecuteIndentation(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		cmd      *ast.TransformCommand
+		expected string
+	}{
+		{
+			"indent default amount skips empty lines",
+			"a\n\nb\n",
+			&ast.TransformCommand{Type: ast.TransformIndent},
+			"    a\n\n    b\n",
+		},
+		{
+			"indent line range",
+			"a\nb\nc\nd\n",
+			&ast.TransformCommand{Type: ast.TransformIndent, Amount: 2, LineRange: &ast.LineRange{Start: 2, End: 3}},
+			"a\n  b\n  c\nd\n",
+		},
+		{
+			"indent with tabs",
+			"a\n",
+			&ast.TransformCommand{Type: ast.TransformIndent, Amount: 1, UseTabs: true},
+			"\ta\n",
+		},
+		{
+			"dedent stops at text",
+			"    a\n b\nc\n",
+			&ast.TransformCommand{Type: ast.TransformDedent, Amount: 2},
+			"  a\nb\nc\n",
+		},
+		{
+			"dedent single line",
+			"  a\n  b\n",
+			&ast.TransformCommand{Type: ast.TransformDedent, Amount: 2, LineRange: &ast.LineRange{Start: 2}},
+			"  a\nb\n",
+		},
+		{
+			"remove common indentation",
+			"    a:\n      b: 1\n\n    c: 2\n",
+			&ast.TransformCommand{Type: ast.TransformDedentCommon},
+			"a:\n  b: 1\n\nc: 2\n",
+		},
+		{
+			"remove common indentation mixed tabs",
+			"\t  a\n\tb\n",
+			&ast.TransformCommand{Type: ast.TransformDedentCommon},
+			"  a\nb\n",
+		},
+		{
+			"tabs to spaces uses tab stops",
+			"\ta\tb\nab\tc\n",
+			&ast.TransformCommand{Type: ast.TransformTabsToSpaces, Amount: 4},
+			"    a   b\nab  c\n",
+		},
+		{
+			"leading spaces to tabs",
+			"      a  b\n  c\n",
+			&ast.TransformCommand{Type: ast.TransformSpacesToTabs, Amount: 4},
+			"\t  a  b\n  c\n",
+		},
+		{
+			"empty input",
+			"",
+			&ast.TransformCommand{Type: ast.TransformDedentCommon},
+			"",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := strings.NewReader(tt.input)
+			var output bytes.Buffer
+
+			err := Execute(tt.cmd, input, &output)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if output.String() != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, output.String())
+			}
+		})
+	}
+}
+
+func TestExecuteBlankLines(t *testing.T) {
+	input := "\n  \na\n\n\n \nb\n\n  \n"
+
+	tests := []struct {
+		name      string
+		blankType ast.BlankLinesType
+		expected  string
+	}{
+		{"delete empty lines", ast.BlankDeleteEmpty, "  \na\n \nb\n  \n"},
+		{"delete blank lines", ast.BlankDeleteBlank, "a\nb\n"},
+		{"squeeze blank lines", ast.BlankSqueeze, "\na\n\nb\n\n"},
+		{"delete leading blank lines", ast.BlankDeleteLeading, "a\n\n\n \nb\n\n  \n"},
+		{"delete trailing blank lines", ast.BlankDeleteTrailing, "\n  \na\n\n\n \nb\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := &ast.BlankLinesCommand{Type: tt.blankType}
+			var output bytes.Buffer
+
+			err := Execute(cmd, strings.NewReader(input), &output)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if output.String() != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, output.String())
+			}
+		})
+	}
+}
+
+func TestExecuteDeleteTrailingBlankLinesLongRun(t *testing.T) {
+	tests := []struct {
+		name string
+		run  string
+	}{
+		{"identical", strings.Repeat("\n", 100000)},
+		{"alternating", strings.Repeat(" \n  \n", 550)},
+		{"both", strings.Repeat("\n", 100000) + strings.Repeat(" \n\t\n", 3*maxBlankRuns)},
+		{"mixed endings", strings.Repeat(" \r\n \n", 3*maxBlankRuns)},
+	}
+
+	cmd := &ast.BlankLinesCommand{Type: ast.BlankDeleteTrailing}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var output bytes.Buffer
+
+			input := strings.NewReader("a\n" + tt.run + "b\n" + tt.run + "c\n" + tt.run)
+			if err := ExecuteWithOptions(cmd, input, &output, Options{}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			// Held-back lines must come out unchanged, however many there are.
+			if expected := "a\n" + tt.run + "b\n" + tt.run + "c\n"; output.String() != expected {
+				t.Errorf("expected %d bytes, got %d", len(expected), output.Len())
+			}
+		})
+	}
+}
+
+func TestExecuteRemove(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		cmd      *ast.RemoveCommand
+		expected string
+	}{
+		{
+			"remove literal",
+			"foo bar foo\n",
+			&ast.RemoveCommand{Type: ast.RemoveText, Target: "foo"},
+			" bar \n",
+		},
+		{
+			"remove regex",
+			"id=42 x=7\n",
+			&ast.RemoveCommand{Type: ast.RemoveText, Target: "[0-9]+", IsRegex: true},
+			"id= x=\n",
+		},
+		{
+			"remove everything after",
+			"value # comment\nplain\n",
+			&ast.RemoveCommand{Type: ast.RemoveAfter, Target: "#"},
+			"value #\nplain\n",
+		},
+		{
+			"remove everything from",
+			"value # comment\n",
+			&ast.RemoveCommand{Type: ast.RemoveFrom, Target: " #"},
+			"value\n",
+		},
+		{
+			"remove everything before regex",
+			"key:   value\n",
+			&ast.RemoveCommand{Type: ast.RemoveBefore, Target: `:\s*`, IsRegex: true},
+			":   value\n",
+		},
+		{
+			"remove text between",
+			"f(a, b) + g(c)\nh(\n",
+			&ast.RemoveCommand{Type: ast.RemoveBetween, Target: "(", End: ")"},
+			"f() + g()\nh(\n",
+		},
+		{
+			"empty input",
+			"",
+			&ast.RemoveCommand{Type: ast.RemoveText, Target: "x"},
+			"",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := strings.NewReader(tt.input)
+			var output bytes.Buffer
+
+			err := Execute(tt.cmd, input, &output)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if output.String() != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, output.String())
+			}
+		})
+	}
+}
+
+func TestExecuteAffix(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		cmd      *ast.AffixCommand
+		expected string
+	}{
+		{
+			"prefix line range",
+			"a\nb\nc\n",
+			&ast.AffixCommand{Position: ast.AffixPrefix, Text: "// ", LineRange: &ast.LineRange{Start: 2, End: 3}},
+			"a\n// b\n// c\n",
+		},
+		{
+			"suffix lines not ending with",
+			"a;\nb\n",
+			&ast.AffixCommand{Position: ast.AffixSuffix, Text: ";", Target: ";", PatternType: ast.PatternEndsWith, Negated: true},
+			"a;\nb;\n",
+		},
+		{
+			"surround literal",
+			"TODO: fix TODO\nnone\n",
+			&ast.AffixCommand{Position: ast.AffixSurround, Text: "**", ClosingText: "**", Match: "TODO"},
+			"**TODO**: fix **TODO**\nnone\n",
+		},
+		{
+			"surround regex in matching lines",
+			"total 12\nitem 3\n",
+			&ast.AffixCommand{
+				Position:     ast.AffixSurround,
+				Text:         "(",
+				ClosingText:  ")",
+				Match:        "[0-9]+",
+				MatchIsRegex: true,
+				Target:       "total",
+			},
+			"total (12)\nitem 3\n",
+		},
+		{
+			"toggle comment",
+			"  code\n  # commented\n\n",
+			&ast.AffixCommand{Position: ast.AffixToggleComment, Text: "#"},
+			"  # code\n  commented\n\n",
+		},
+		{
+			"toggle comment marker with trailing space",
+			"// x\ny\n",
+			&ast.AffixCommand{Position: ast.AffixToggleComment, Text: "// "},
+			"x\n// y\n",
+		},
+		{
+			"empty input",
+			"",
+			&ast.AffixCommand{Position: ast.AffixPrefix, Text: "> "},
+			"",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := strings.NewReader(tt.input)
+			var output bytes.Buffer
+
+			err := Execute(tt.cmd, input, &output)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if output.String() != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, output.String())
+			}
+		})
+	}
+}
+
+func TestExecuteLayout(t *testing.T) {
+	tests := []struct {
+		name       string
+		input      string
+		layoutType ast.LayoutType
+		width      int
+		expected   string
+	}{
+		{
+			"wrap long line",
+			"the quick brown fox jumps\nshort\n",
+			ast.LayoutWrap,
+			10,
+			"the quick\nbrown fox\njumps\nshort\n",
+		},
+		{
+			"wrap keeps indentation",
+			"    alpha beta gamma\n",
+			ast.LayoutWrap,
+			12,
+			"    alpha\n    beta\n    gamma\n",
+		},
+		{
+			"wrap whitespace only line",
+			strings.Repeat(" ", 20) + "\n",
+			ast.LayoutWrap,
+			10,
+			strings.Repeat(" ", 20) + "\n",
+		},
+		{
+			"fill paragraphs",
+			"one two\nthree four five\n\nsix\nseven\n",
+			ast.LayoutFill,
+			9,
+			"one two\nthree\nfour five\n\nsix seven\n",
+		},
+		{
+			"center lines",
+			"  hi  \n\nabc\n",
+			ast.LayoutCenter,
+			7,
+			"  hi\n\n  abc\n",
+		},
+		{
+			"center counts wide runes twice",
+			"日本\n",
+			ast.LayoutCenter,
+			8,
+			"  日本\n",
+		},
+		{
+			"right-align lines",
+			"abc\n  de\n",
+			ast.LayoutRightAlign,
+			5,
+			"  abc\n   de\n",
+		},
+		{
+			"right-align wider than width",
+			"abcdef\n",
+			ast.LayoutRightAlign,
+			3,
+			"abcdef\n",
+		},
+		{
+			"empty input",
+			"",
+			ast.LayoutFill,
+			0,
+			"",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := &ast.LayoutCommand{Type: tt.layoutType, Width: tt.width}
+			input := strings.NewReader(tt.input)
+			var output bytes.Buffer
+
+			err := Execute(cmd, input, &output)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if output.String() != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, output.String())
+			}
+		})
+	}
+}
+
+func TestDisplayWidth(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int
+	}{
+		{"hello", 5},
+		{"日本語", 6},
+		{"e\u0301", 1},
+		{"a\tb", 9},
+		{"", 0},
+	}
+
+	for _, tt := range tests {
+		if got := displayWidth(tt.input); got != tt.expected {
+			t.Errorf("displayWidth(%q): expected %d, got %d", tt.input, tt.expected, got)
+		}
+	}
+}
+
 func TestExecuteCount(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -1022,3 +1450,600 @@ func TestExecuteCompound(t *testing.T) {
 		})
 	}
 }
+
+func TestExecuteWithOptionsLineEndings(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		cmd      ast.Command
+		ending   LineEnding
+		expected string
+	}{
+		{
+			"keeps crlf",
+			"foo\r\nbar\r\n",
+			&ast.ReplaceCommand{Source: "foo", Replacement: "baz"},
+			LineEndingKeep,
+			"baz\r\nbar\r\n",
+		},
+		{
+			"keeps missing final newline",
+			"foo\nbar",
+			&ast.ReplaceCommand{Source: "bar", Replacement: "qux"},
+			LineEndingKeep,
+			"foo\nqux",
+		},
+		{
+			"keeps crlf without final newline",
+			"foo\r\nbar",
+			&ast.TransformCommand{Type: ast.TransformUppercase},
+			LineEndingKeep,
+			"FOO\r\nBAR",
+		},
+		{
+			"keeps mixed endings",
+			"a\nb\r\nc\n",
+			&ast.ReplaceCommand{Source: "b", Replacement: "x"},
+			LineEndingKeep,
+			"a\nx\r\nc\n",
+		},
+		{
+			"final newline kept when the last line isn't shown",
+			"a\nb",
+			&ast.ShowCommand{Target: "a"},
+			LineEndingKeep,
+			"a\n",
+		},
+		{
+			"missing final newline moves after an appended line",
+			"a\nb",
+			&ast.InsertCommand{Text: "z", Position: ast.InsertAppend},
+			LineEndingKeep,
+			"a\nb\nz",
+		},
+		{
+			"forces lf on mixed endings",
+			"a\r\nb\nc\r\n",
+			&ast.TransformCommand{Type: ast.TransformUppercase},
+			LineEndingLF,
+			"A\nB\nC\n",
+		},
+		{
+			"forces lf",
+			"foo\r\nbar\r\n",
+			&ast.TransformCommand{Type: ast.TransformUppercase},
+			LineEndingLF,
+			"FOO\nBAR\n",
+		},
+		{
+			"forces crlf",
+			"foo\nbar\n",
+			&ast.TransformCommand{Type: ast.TransformUppercase},
+			LineEndingCRLF,
+			"FOO\r\nBAR\r\n",
+		},
+		{
+			"convert command overrides option",
+			"foo\nbar\n",
+			&ast.CompoundCommand{Commands: []ast.Command{
+				&ast.ReplaceCommand{Source: "foo", Replacement: "baz"},
+				&ast.LineEndingCommand{Style: ast.LineEndingCRLF},
+			}},
+			LineEndingLF,
+			"baz\r\nbar\r\n",
+		},
+		{
+			"stopping early keeps final newline",
+			"a\nb\nc",
+			&ast.ShowCommand{FirstN: 1},
+			LineEndingKeep,
+			"a\n",
+		},
+		{
+			"count output is unaffected",
+			"foo\r\nfoo",
+			&ast.CountCommand{Target: "foo"},
+			LineEndingKeep,
+			"2\n",
+		},
+		{
+			"empty input",
+			"",
+			&ast.TransformCommand{Type: ast.TransformUppercase},
+			LineEndingKeep,
+			"",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var output bytes.Buffer
+
+			err := ExecuteWithOptions(tt.cmd, strings.NewReader(tt.input), &output, Options{LineEnding: tt.ending})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if output.String() != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, output.String())
+			}
+		})
+	}
+}
+
+func TestExecuteWithOptionsRecordSeparators(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		cmd      ast.Command
+		opts     Options
+		expected string
+	}{
+		{
+			"nul separated",
+			"a.txt\x00b.go\x00c.txt\x00",
+			&ast.ShowCommand{Target: ".txt"},
+			Options{RecordSeparator: "\x00"},
+			"a.txt\x00c.txt\x00",
+		},
+		{
+			"nul records keep newlines",
+			"one\ntwo\x00three",
+			&ast.TransformCommand{Type: ast.TransformUppercase},
+			Options{RecordSeparator: "\x00"},
+			"ONE\nTWO\x00THREE",
+		},
+		{
+			"multi-byte literal",
+			"a;;b;;c",
+			&ast.DeleteCommand{Target: "b"},
+			Options{RecordSeparator: ";;"},
+			"a;;c",
+		},
+		{
+			"paragraph mode",
+			"one\ntwo\n\n\nerror\nthree\n\nfour\n",
+			&ast.DeleteCommand{Target: "error"},
+			Options{RecordRegexp: regexp.MustCompile(`\n\n+`)},
+			"one\ntwo\n\n\nfour\n",
+		},
+		{
+			"regex separators are kept as found",
+			"a\n\nb\n\n\n\nc\n",
+			&ast.ReplaceCommand{Source: "zzz", Replacement: "y"},
+			Options{RecordRegexp: regexp.MustCompile(`\n\n+`)},
+			"a\n\nb\n\n\n\nc\n",
+		},
+		{
+			"regex without match falls back to newline",
+			"just one\n",
+			&ast.ReplaceCommand{Source: "one$", Replacement: "two", IsRegex: true},
+			Options{RecordRegexp: regexp.MustCompile(`\n\n+`)},
+			"just two\n",
+		},
+		{
+			"records start at matching lines",
+			"2024-01-01 ok\n  detail\n2024-01-02 error\n  trace\n2024-01-03 ok\n",
+			&ast.DeleteCommand{Target: "error"},
+			Options{RecordRegexp: regexp.MustCompile(`^\d{4}-\d\d-\d\d`)},
+			"2024-01-01 ok\n  detail\n2024-01-03 ok\n",
+		},
+		{
+			"record start keeps crlf",
+			"2024-01-01 a\r\n  b\r\n2024-01-02 c\r\n",
+			&ast.ShowCommand{Target: "b"},
+			Options{RecordRegexp: regexp.MustCompile(`^\d{4}`)},
+			"2024-01-01 a\r\n  b\r\n",
+		},
+		{
+			"count counts records",
+			"x\ny\x00x\x00z",
+			&ast.CountCommand{Target: "x"},
+			Options{RecordSeparator: "\x00"},
+			"2\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var output bytes.Buffer
+
+			err := ExecuteWithOptions(tt.cmd, strings.NewReader(tt.input), &output, tt.opts)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if output.String() != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, output.String())
+			}
+		})
+	}
+
+	t.Run("empty match rejected", func(t *testing.T) {
+		err := ExecuteWithOptions(&ast.ShowCommand{Target: "x"}, strings.NewReader("x"), io.Discard, Options{RecordRegexp: regexp.MustCompile(`x*`)})
+		if err == nil {
+			t.Error("expected error for separator matching the empty string")
+		}
+	})
+}
+
+func TestExecuteWithOptionsEncoding(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		cmd      ast.Command
+		encoding Encoding
+		expected string
+	}{
+		{
+			"utf-16le bom round trip",
+			"\xff\xfeh\x00i\x00\r\x00\n\x00",
+			&ast.ReplaceCommand{Source: "hi", Replacement: "hé"},
+			EncodingAuto,
+			"\xff\xfeh\x00\xe9\x00\r\x00\n\x00",
+		},
+		{
+			"utf-16be surrogate pair",
+			"\xfe\xff\xd8\x3d\xde\x00\x00\n",
+			&ast.TransformCommand{Type: ast.TransformUppercase},
+			EncodingAuto,
+			"\xfe\xff\xd8\x3d\xde\x00\x00\n",
+		},
+		{
+			"utf-8 bom kept",
+			"\xef\xbb\xbffoo\n",
+			&ast.ReplaceCommand{Source: "foo", Replacement: "bar"},
+			EncodingAuto,
+			"\xef\xbb\xbfbar\n",
+		},
+		{
+			"utf-8 bom does not hide first line",
+			"\xef\xbb\xbf# title\nbody\n",
+			&ast.DeleteCommand{Target: "#", PatternType: ast.PatternStartsWith},
+			EncodingAuto,
+			"\xef\xbb\xbfbody\n",
+		},
+		{
+			"latin1",
+			"caf\xe9\n",
+			&ast.ReplaceCommand{Source: "café", Replacement: "thé"},
+			EncodingLatin1,
+			"th\xe9\n",
+		},
+		{
+			"windows-1252",
+			"\x93quoted\x94 \x80\n",
+			&ast.ReplaceCommand{Source: "€", Replacement: "EUR"},
+			EncodingWindows1252,
+			"\x93quoted\x94 EUR\n",
+		},
+		{
+			"utf-16le without bom",
+			"a\x00\n\x00",
+			&ast.TransformCommand{Type: ast.TransformUppercase},
+			EncodingUTF16LE,
+			"A\x00\n\x00",
+		},
+		{
+			"convert to utf-8 drops bom",
+			"\xff\xfeh\x00i\x00\n\x00",
+			&ast.EncodingCommand{Name: "utf-8"},
+			EncodingAuto,
+			"hi\n",
+		},
+		{
+			"convert latin1 to utf-16le adds bom",
+			"\xe9\n",
+			&ast.EncodingCommand{Name: "utf-16le"},
+			EncodingLatin1,
+			"\xff\xfe\xe9\x00\n\x00",
+		},
+		{
+			"bom only",
+			"\xff\xfe",
+			&ast.TransformCommand{Type: ast.TransformUppercase},
+			EncodingAuto,
+			"\xff\xfe",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var output bytes.Buffer
+
+			err := ExecuteWithOptions(tt.cmd, strings.NewReader(tt.input), &output, Options{Encoding: tt.encoding})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if output.String() != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, output.String())
+			}
+		})
+	}
+
+	t.Run("unencodable character", func(t *testing.T) {
+		cmd := &ast.ReplaceCommand{Source: "a", Replacement: "日"}
+
+		err := ExecuteWithOptions(cmd, strings.NewReader("a\n"), io.Discard, Options{Encoding: EncodingLatin1})
+		if err == nil || !strings.Contains(err.Error(), "latin1") {
+			t.Errorf("expected latin1 encoding error, got %v", err)
+		}
+	})
+
+	t.Run("unknown target encoding", func(t *testing.T) {
+		err := ExecuteWithOptions(&ast.EncodingCommand{Name: "ebcdic"}, strings.NewReader("a\n"), io.Discard, Options{})
+		if err == nil {
+			t.Error("expected error for unknown encoding")
+		}
+	})
+}
+
+func TestLooksBinary(t *testing.T) {
+	tests := []struct {
+		name     string
+		head     string
+		encoding Encoding
+		expected bool
+	}{
+		{"text", "hello\nworld\n", EncodingAuto, false},
+		{"nul byte", "\x7fELF\x02\x01\x01\x00", EncodingAuto, true},
+		{"invalid utf-8", "caf\xe9\n", EncodingAuto, true},
+		{"cut off rune", "日本\xe8\xaa", EncodingAuto, false},
+		{"utf-16 bom", "\xff\xfeh\x00i\x00", EncodingAuto, false},
+		{"utf-16 without bom", "h\x00i\x00", EncodingUTF16LE, false},
+		{"latin1", "caf\xe9\n", EncodingLatin1, false},
+		{"latin1 nul byte", "a\x00b", EncodingLatin1, true},
+		{"empty", "", EncodingAuto, false},
+	}
+
+	for _, tt := range tests {
+		if got := LooksBinary([]byte(tt.head), tt.encoding); got != tt.expected {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.expected, got)
+		}
+	}
+}
+
+func TestExecuteCompoundStopsEarly(t *testing.T) {
+	input := strings.Repeat("line\n", 100000)
+	cmd := &ast.CompoundCommand{Commands: []ast.Command{
+		&ast.ReplaceCommand{Source: "line", Replacement: "row"},
+		&ast.TransformCommand{Type: ast.TransformUppercase},
+		&ast.ShowCommand{FirstN: 2},
+	}}
+
+	var output bytes.Buffer
+
+	if err := Execute(cmd, strings.NewReader(input), &output); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if output.String() != "ROW\nROW\n" {
+		t.Errorf("expected %q, got %q", "ROW\nROW\n", output.String())
+	}
+}
+
+func TestExecuteWithOptionsReportsMatches(t *testing.T) {
+	input := "foo 1\nbar\nfoo 2\n"
+
+	tests := []struct {
+		name     string
+		cmd      ast.Command
+		expected []Match
+	}{
+		{
+			"show",
+			&ast.ShowCommand{Target: "foo"},
+			[]Match{{Line: 1, Text: "foo 1", Spans: []Span{{0, 3}}}, {Line: 3, Text: "foo 2", Spans: []Span{{0, 3}}}},
+		},
+		{
+			"show last",
+			&ast.ShowCommand{LastN: 2},
+			[]Match{{Line: 2, Text: "bar"}, {Line: 3, Text: "foo 2"}},
+		},
+		{
+			"count",
+			&ast.CountCommand{Target: "bar"},
+			[]Match{{Line: 2, Text: "bar", Spans: []Span{{0, 3}}}},
+		},
+		{
+			"no match",
+			&ast.ShowCommand{Target: "baz"},
+			nil,
+		},
+		{
+			"show inside a pipeline",
+			&ast.CompoundCommand{Commands: []ast.Command{
+				&ast.ShowCommand{Target: "foo"},
+				&ast.TransformCommand{Type: ast.TransformUppercase},
+			}},
+			nil,
+		},
+		{
+			"show at the end of a pipeline",
+			&ast.CompoundCommand{Commands: []ast.Command{
+				&ast.TransformCommand{Type: ast.TransformUppercase},
+				&ast.ShowCommand{Target: "BAR"},
+			}},
+			[]Match{{Line: 2, Text: "BAR", Spans: []Span{{0, 3}}}},
+		},
+		{
+			"original line numbers through a pipeline",
+			&ast.CompoundCommand{Commands: []ast.Command{
+				&ast.DeleteCommand{Target: "bar"},
+				&ast.ShowCommand{Target: "foo"},
+			}},
+			[]Match{{Line: 1, Text: "foo 1", Spans: []Span{{0, 3}}}, {Line: 3, Text: "foo 2", Spans: []Span{{0, 3}}}},
+		},
+		{
+			"original line numbers after delete last",
+			&ast.CompoundCommand{Commands: []ast.Command{
+				&ast.DeleteCommand{LastN: 1},
+				&ast.ShowCommand{LastN: 1},
+			}},
+			[]Match{{Line: 2, Text: "bar"}},
+		},
+		{
+			"original line numbers after insert",
+			&ast.CompoundCommand{Commands: []ast.Command{
+				&ast.InsertCommand{Text: "foo 0", Position: ast.InsertAfter, Reference: "bar"},
+				&ast.ShowCommand{Target: "foo"},
+			}},
+			[]Match{{Line: 1, Text: "foo 1", Spans: []Span{{0, 3}}}, {Line: 2, Text: "foo 0", Spans: []Span{{0, 3}}}, {Line: 3, Text: "foo 2", Spans: []Span{{0, 3}}}},
+		},
+		{
+			"original line numbers after dedent",
+			&ast.CompoundCommand{Commands: []ast.Command{
+				&ast.TransformCommand{Type: ast.TransformDedentCommon},
+				&ast.ShowCommand{Target: "bar"},
+			}},
+			[]Match{{Line: 2, Text: "bar", Spans: []Span{{0, 3}}}},
+		},
+		{
+			"original line numbers after fill",
+			&ast.CompoundCommand{Commands: []ast.Command{
+				&ast.LayoutCommand{Type: ast.LayoutFill, Width: 5},
+				&ast.ShowCommand{Target: "bar"},
+			}},
+			[]Match{{Line: 1, Text: "bar", Spans: []Span{{0, 3}}}},
+		},
+		{
+			"regex spans",
+			&ast.ShowCommand{Target: `[0-9]`, IsRegex: true},
+			[]Match{{Line: 1, Text: "foo 1", Spans: []Span{{4, 5}}}, {Line: 3, Text: "foo 2", Spans: []Span{{4, 5}}}},
+		},
+		{
+			"every occurrence",
+			&ast.CountCommand{Target: "o"},
+			[]Match{{Line: 1, Text: "foo 1", Spans: []Span{{1, 2}, {2, 3}}}, {Line: 3, Text: "foo 2", Spans: []Span{{1, 2}, {2, 3}}}},
+		},
+		{
+			"negated show has no spans",
+			&ast.ShowCommand{Target: "foo", Negated: true},
+			[]Match{{Line: 2, Text: "bar"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var matches []Match
+
+			opts := Options{OnMatch: func(m Match) { matches = append(matches, m) }}
+			if err := ExecuteWithOptions(tt.cmd, strings.NewReader(input), io.Discard, opts); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if fmt.Sprint(matches) != fmt.Sprint(tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, matches)
+			}
+		})
+	}
+}
+
+func TestExecuteWithOptionsFormat(t *testing.T) {
+	opts := Options{Format: func(m Match) string { return fmt.Sprintf("%d:%s", m.Line, m.Text) }}
+
+	var output bytes.Buffer
+
+	cmd := &ast.CompoundCommand{Commands: []ast.Command{
+		&ast.DeleteCommand{Target: "bar"},
+		&ast.ShowCommand{Target: "foo"},
+	}}
+	if err := ExecuteWithOptions(cmd, strings.NewReader("foo 1\r\nbar\r\nfoo 2\r\n"), &output, opts); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if expected := "1:foo 1\r\n3:foo 2\r\n"; output.String() != expected {
+		t.Errorf("expected %q, got %q", expected, output.String())
+	}
+}
+
+func TestExecuteLongLines(t *testing.T) {
+	long := strings.Repeat("x", 11*1024*1024)
+
+	tests := []struct {
+		name     string
+		cmd      ast.Command
+		input    string
+		expected string
+	}{
+		{
+			"replace in long line",
+			&ast.ReplaceCommand{Source: "y", Replacement: "z"},
+			"a\n" + long + "y\nb\n",
+			"a\n" + long + "z\nb\n",
+		},
+		{
+			"show long line",
+			&ast.ShowCommand{Target: "y"},
+			"a\n" + long + "y\nb\n",
+			long + "y\n",
+		},
+		{
+			"delete long line",
+			&ast.DeleteCommand{Target: "y"},
+			"a\n" + long + "y\nb",
+			"a\nb\n",
+		},
+		{
+			"count long lines",
+			&ast.CountCommand{Target: "x"},
+			long + "\n" + long + "\r\n",
+			"2\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var output bytes.Buffer
+
+			err := Execute(tt.cmd, strings.NewReader(tt.input), &output)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if output.String() != tt.expected {
+				t.Errorf("expected %d bytes, got %d bytes", len(tt.expected), output.Len())
+			}
+		})
+	}
+}
+
+type failingReader struct {
+	data string
+	err  error
+}
+
+func (f *failingReader) Read(p []byte) (int, error) {
+	if f.data == "" {
+		return 0, f.err
+	}
+
+	n := copy(p, f.data)
+	f.data = f.data[n:]
+
+	return n, nil
+}
+
+func TestExecuteReadErrorReportsLine(t *testing.T) {
+	readErr := errors.New("disk on fire")
+	input := &failingReader{data: "one\ntwo\nthr", err: readErr}
+
+	err := Execute(&ast.ReplaceCommand{Source: "o", Replacement: "0"}, input, io.Discard)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+
+	var lineErr *LineError
+	if !errors.As(err, &lineErr) {
+		t.Fatalf("expected LineError, got %T: %v", err, err)
+	}
+
+	if lineErr.Line != 3 {
+		t.Errorf("expected line 3, got %d", lineErr.Line)
+	}
+
+	if !errors.Is(err, readErr) {
+		t.Errorf("expected wrapped read error, got %v", err)
+	}
+}
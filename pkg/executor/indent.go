@@ -0,0 +1,127 @@
+package executor
+
+import (
+	"strings"
+)
+
+const defaultIndentWidth = 4
+
+func indentLine(line string, amount int, useTabs bool) string {
+	if line == "" {
+		return line
+	}
+
+	if useTabs {
+		return strings.Repeat("\t", amount) + line
+	}
+
+	return strings.Repeat(" ", amount) + line
+}
+
+func dedentLine(line string, amount int) string {
+	i := 0
+
+	for i < len(line) && i < amount && (line[i] == ' ' || line[i] == '\t') {
+		i++
+	}
+
+	return line[i:]
+}
+
+func expandTabs(line string, width int) string {
+	if !strings.Contains(line, "\t") {
+		return line
+	}
+
+	var b strings.Builder
+
+	column := 0
+
+	for _, r := range line {
+		if r == '\t' {
+			spaces := width - column%width
+			b.WriteString(strings.Repeat(" ", spaces))
+			column += spaces
+
+			continue
+		}
+
+		b.WriteRune(r)
+		column += runeWidth(r)
+	}
+
+	return b.String()
+}
+
+func unexpandLeading(line string, width int) string {
+	indent, rest := splitIndent(line)
+	if indent == "" {
+		return line
+	}
+
+	column := 0
+
+	for _, r := range indent {
+		if r == '\t' {
+			column += width - column%width
+		} else {
+			column++
+		}
+	}
+
+	return strings.Repeat("\t", column/width) + strings.Repeat(" ", column%width) + rest
+}
+
+func commonIndent(a, b string) string {
+	n := 0
+
+	for n < len(a) && n < len(b) && a[n] == b[n] {
+		n++
+	}
+
+	return a[:n]
+}
+
+func executeDedentCommon(scanner lineSource, lw lineSink) error {
+	var lines []record
+
+	var common string
+
+	found := false
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		lines = append(lines, current(scanner))
+
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		indent, _ := splitIndent(line)
+
+		if !found {
+			common = indent
+			found = true
+		} else {
+			common = commonIndent(common, indent)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	for _, r := range lines {
+		if strings.TrimSpace(r.text) == "" {
+			r.text = ""
+		} else {
+			r.text = r.text[len(common):]
+		}
+
+		if err := lw.writeRecord(r); err != nil {
+			return err
+		}
+	}
+
+	return lw.flush()
+}
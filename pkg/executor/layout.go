@@ -0,0 +1,228 @@
+package executor
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/Gx2-Studio/ssed/pkg/ast"
+)
+
+const (
+	defaultLayoutWidth = 80
+	tabWidth           = 8
+)
+
+// wideRanges lists the East Asian Wide and Fullwidth blocks that occupy two
+// terminal columns.
+var wideRanges = [][2]rune{
+	{0x1100, 0x115F},
+	{0x231A, 0x231B},
+	{0x2329, 0x232A},
+	{0x23E9, 0x23EC},
+	{0x2E80, 0x303E},
+	{0x3041, 0x33FF},
+	{0x3400, 0x4DBF},
+	{0x4E00, 0x9FFF},
+	{0xA000, 0xA4CF},
+	{0xA960, 0xA97F},
+	{0xAC00, 0xD7A3},
+	{0xF900, 0xFAFF},
+	{0xFE10, 0xFE19},
+	{0xFE30, 0xFE6F},
+	{0xFF00, 0xFF60},
+	{0xFFE0, 0xFFE6},
+	{0x1F300, 0x1F64F},
+	{0x1F900, 0x1F9FF},
+	{0x20000, 0x2FFFD},
+	{0x30000, 0x3FFFD},
+}
+
+func runeWidth(r rune) int {
+	if r == 0 || unicode.Is(unicode.Mn, r) || unicode.Is(unicode.Me, r) || unicode.Is(unicode.Cf, r) {
+		return 0
+	}
+
+	if r < 0x1100 {
+		return 1
+	}
+
+	for _, wr := range wideRanges {
+		if r < wr[0] {
+			break
+		}
+
+		if r <= wr[1] {
+			return 2
+		}
+	}
+
+	return 1
+}
+
+func displayWidth(s string) int {
+	width := 0
+
+	for _, r := range s {
+		if r == '\t' {
+			width += tabWidth - width%tabWidth
+
+			continue
+		}
+
+		width += runeWidth(r)
+	}
+
+	return width
+}
+
+func splitIndent(line string) (string, string) {
+	rest := strings.TrimLeftFunc(line, unicode.IsSpace)
+
+	return line[:len(line)-len(rest)], rest
+}
+
+func wrapWords(indent string, words []string, width int) []string {
+	var lines []string
+
+	var b strings.Builder
+
+	indentWidth := displayWidth(indent)
+	lineWidth := 0
+
+	for _, word := range words {
+		wordWidth := displayWidth(word)
+
+		if lineWidth > 0 && indentWidth+lineWidth+1+wordWidth > width {
+			lines = append(lines, indent+b.String())
+			b.Reset()
+
+			lineWidth = 0
+		}
+
+		if lineWidth > 0 {
+			b.WriteByte(' ')
+			lineWidth++
+		}
+
+		b.WriteString(word)
+		lineWidth += wordWidth
+	}
+
+	if lineWidth > 0 {
+		lines = append(lines, indent+b.String())
+	}
+
+	return lines
+}
+
+func executeLayout(cmd *ast.LayoutCommand, scanner lineSource, lw lineSink) error {
+	width := cmd.Width
+	if width <= 0 {
+		width = defaultLayoutWidth
+	}
+
+	if cmd.Type == ast.LayoutFill {
+		return executeFill(scanner, lw, width)
+	}
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		switch cmd.Type {
+		case ast.LayoutWrap:
+			indent, rest := splitIndent(line)
+			if rest == "" || displayWidth(line) <= width {
+				break
+			}
+
+			wrapped := wrapWords(indent, strings.Fields(rest), width)
+
+			for _, w := range wrapped[:len(wrapped)-1] {
+				if err := lw.writeLine(w); err != nil {
+					return err
+				}
+			}
+
+			line = wrapped[len(wrapped)-1]
+		case ast.LayoutCenter:
+			text := strings.TrimSpace(line)
+			if text != "" {
+				line = strings.Repeat(" ", max(0, (width-displayWidth(text))/2)) + text
+			} else {
+				line = ""
+			}
+		case ast.LayoutRightAlign:
+			text := strings.TrimSpace(line)
+			if text != "" {
+				line = strings.Repeat(" ", max(0, width-displayWidth(text))) + text
+			} else {
+				line = ""
+			}
+		}
+
+		if err := lw.writeLine(line); err != nil {
+			return err
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	return lw.flush()
+}
+
+func executeFill(scanner lineSource, lw lineSink, width int) error {
+	var words []string
+
+	var indent string
+
+	// The filled lines take the number of the paragraph's first line.
+	var first record
+
+	flushParagraph := func() error {
+		for _, w := range wrapWords(indent, words, width) {
+			first.text = w
+			if err := lw.writeRecord(first); err != nil {
+				return err
+			}
+		}
+
+		words = words[:0]
+
+		return nil
+	}
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if strings.TrimSpace(line) == "" {
+			if err := flushParagraph(); err != nil {
+				return err
+			}
+
+			if err := lw.writeLine(line); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if len(words) == 0 {
+			indent, _ = splitIndent(line)
+			first = current(scanner)
+		}
+
+		words = append(words, strings.Fields(line)...)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	if err := flushParagraph(); err != nil {
+		return err
+	}
+
+	return lw.flush()
+}
@@ -0,0 +1,610 @@
+package executor
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+const (
+	maxRetainedLineBuffer = 1024 * 1024
+	recordBatchSize       = 256
+)
+
+// LineError reports a failure while reading the given input line.
+type LineError struct {
+	Line int
+	Err  error
+}
+
+func (e *LineError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+func (e *LineError) Unwrap() error {
+	return e.Err
+}
+
+// lineSource is what a command reads records from. Line is the number of the
+// current record in the original input and End the terminator it was read
+// with, empty for an unterminated last record.
+type lineSource interface {
+	Scan() bool
+	Text() string
+	Line() int
+	End() string
+	Err() error
+}
+
+// lineSink is what a command writes records to. show and count hand their
+// matches to writeMatch and reportMatch.
+type lineSink interface {
+	writeLine(line string) error
+	writeRecord(r record) error
+	writeMatch(r record, spans []Span) error
+	reportMatch(m Match)
+	reportsMatches() bool
+	flush() error
+}
+
+// Match is a record selected by show or counted by count. Line is its number
+// in the original input and Spans are where the pattern occurs in Text.
+type Match struct {
+	Line  int
+	Text  string
+	Spans []Span
+}
+
+// Span is a byte range of a record.
+type Span struct {
+	Start, End int
+}
+
+// record is a record together with its number in the original input and
+// its terminator.
+type record struct {
+	text string
+	line int
+	end  string
+}
+
+// current returns the record src is at, for commands that hold records back.
+func current(src lineSource) record {
+	return record{text: src.Text(), line: src.Line(), end: src.End()}
+}
+
+// lineScanner is bufio.ScanLines for any delimiter, without a maximum length.
+type lineScanner struct {
+	r       *bufio.Reader
+	delim   byte
+	sep     string
+	buf     []byte
+	line    string
+	lineNum int
+	end     string
+	err     error
+}
+
+func newScanner(input io.Reader) *lineScanner {
+	return newDelimScanner(input, '\n')
+}
+
+func newDelimScanner(input io.Reader, delim byte) *lineScanner {
+	sep := string(delim)
+
+	return &lineScanner{r: bufio.NewReaderSize(input, 64*1024), delim: delim, sep: sep, end: sep}
+}
+
+func (s *lineScanner) Scan() bool {
+	if s.err != nil {
+		return false
+	}
+
+	s.buf = s.buf[:0]
+
+	for {
+		chunk, err := s.r.ReadSlice(s.delim)
+
+		switch err {
+		case nil:
+			if len(s.buf) == 0 {
+				s.setLine(chunk)
+			} else {
+				s.setLine(append(s.buf, chunk...))
+			}
+
+			return true
+		case bufio.ErrBufferFull:
+			s.buf = append(s.buf, chunk...)
+		case io.EOF:
+			s.buf = append(s.buf, chunk...)
+			if len(s.buf) == 0 {
+				return false
+			}
+
+			s.setLine(s.buf)
+
+			return true
+		default:
+			s.err = &LineError{Line: s.lineNum + 1, Err: err}
+
+			return false
+		}
+	}
+}
+
+func (s *lineScanner) setLine(data []byte) {
+	s.end = ""
+
+	if data[len(data)-1] == s.delim {
+		data = data[:len(data)-1]
+		s.end = s.sep
+
+		if s.delim == '\n' && len(data) > 0 && data[len(data)-1] == '\r' {
+			data = data[:len(data)-1]
+			s.end = "\r\n"
+		}
+	}
+
+	s.line = string(data)
+	s.lineNum++
+
+	if cap(s.buf) > maxRetainedLineBuffer {
+		s.buf = nil
+	}
+}
+
+func (s *lineScanner) Text() string {
+	return s.line
+}
+
+func (s *lineScanner) Line() int {
+	return s.lineNum
+}
+
+func (s *lineScanner) End() string {
+	return s.end
+}
+
+func (s *lineScanner) Err() error {
+	return s.err
+}
+
+// splitScanner splits input on a literal or a regex. A regex match only
+// counts once more input follows it, so `\n\n+` isn't cut short.
+type splitScanner struct {
+	r       io.Reader
+	literal []byte
+	re      *regexp.Regexp
+	buf     []byte
+	start   int
+	eof     bool
+	sep     string
+	line    string
+	lineNum int
+	end     string
+	err     error
+}
+
+func newSplitScanner(input io.Reader, literal string, re *regexp.Regexp) *splitScanner {
+	return &splitScanner{r: input, literal: []byte(literal), re: re, sep: literal}
+}
+
+func (s *splitScanner) find(data []byte) (int, int) {
+	if s.re == nil {
+		i := bytes.Index(data, s.literal)
+		if i < 0 {
+			return -1, -1
+		}
+
+		return i, i + len(s.literal)
+	}
+
+	loc := s.re.FindIndex(data)
+	if loc == nil || (loc[1] == len(data) && !s.eof) {
+		return -1, -1
+	}
+
+	return loc[0], loc[1]
+}
+
+func (s *splitScanner) fill() {
+	if s.start > 0 && s.start >= len(s.buf)/2 {
+		n := copy(s.buf, s.buf[s.start:])
+		s.buf = s.buf[:n]
+		s.start = 0
+	}
+
+	if cap(s.buf)-len(s.buf) < 32*1024 {
+		grown := make([]byte, len(s.buf), 2*cap(s.buf)+64*1024)
+		copy(grown, s.buf)
+		s.buf = grown
+	}
+
+	n, err := s.r.Read(s.buf[len(s.buf):cap(s.buf)])
+	s.buf = s.buf[:len(s.buf)+n]
+
+	if err == io.EOF {
+		s.eof = true
+	} else if err != nil {
+		s.err = &LineError{Line: s.lineNum + 1, Err: err}
+	}
+}
+
+// separator returns the first separator in the input, which follows records
+// that had none of their own.
+func (s *splitScanner) separator() string {
+	if s.re == nil {
+		return s.sep
+	}
+
+	s.sep = "\n"
+
+	for s.err == nil {
+		data := s.buf[s.start:]
+		if from, to := s.find(data); from >= 0 {
+			s.sep = string(data[from:to])
+
+			break
+		}
+
+		if s.eof {
+			break
+		}
+
+		s.fill()
+	}
+
+	return s.sep
+}
+
+func (s *splitScanner) Scan() bool {
+	for s.err == nil {
+		data := s.buf[s.start:]
+
+		if from, to := s.find(data); from >= 0 && to > from {
+			end := s.sep
+			if s.re != nil {
+				end = string(data[from:to])
+			}
+
+			s.setLine(data[:from], end)
+			s.start += to
+
+			return true
+		}
+
+		if s.eof {
+			if len(data) == 0 {
+				return false
+			}
+
+			// A trailing newline isn't part of the last record.
+			tail := ""
+			if bytes.HasSuffix(data, []byte{'\n'}) && !bytes.Equal(s.literal, []byte{'\n'}) {
+				data = data[:len(data)-1]
+				tail = "\n"
+			}
+
+			s.setLine(data, tail)
+			s.start = len(s.buf)
+
+			return true
+		}
+
+		s.fill()
+	}
+
+	return false
+}
+
+func (s *splitScanner) setLine(data []byte, end string) {
+	s.line = string(data)
+	s.lineNum++
+	s.end = end
+}
+
+func (s *splitScanner) Text() string {
+	return s.line
+}
+
+func (s *splitScanner) Line() int {
+	return s.lineNum
+}
+
+func (s *splitScanner) End() string {
+	return s.end
+}
+
+func (s *splitScanner) Err() error {
+	return s.err
+}
+
+// recordStartScanner starts a new record at every line that matches re.
+type recordStartScanner struct {
+	lines   *lineScanner
+	re      *regexp.Regexp
+	next    string
+	nextEnd string
+	hasNext bool
+	record  strings.Builder
+	line    string
+	lineNum int
+	end     string
+}
+
+func newRecordStartScanner(input io.Reader, re *regexp.Regexp) *recordStartScanner {
+	return &recordStartScanner{lines: newScanner(input), re: re}
+}
+
+func (s *recordStartScanner) Scan() bool {
+	if !s.hasNext {
+		if !s.lines.Scan() {
+			return false
+		}
+
+		s.next, s.nextEnd = s.lines.Text(), s.lines.End()
+	}
+
+	s.record.Reset()
+	s.record.WriteString(s.next)
+	s.end = s.nextEnd
+	s.hasNext = false
+
+	// The lines inside a record keep their own terminators.
+	for s.lines.Scan() {
+		line := s.lines.Text()
+		if s.re.MatchString(line) {
+			s.next, s.nextEnd = line, s.lines.End()
+			s.hasNext = true
+
+			break
+		}
+
+		s.record.WriteString(s.end)
+		s.record.WriteString(line)
+		s.end = s.lines.End()
+	}
+
+	s.line = s.record.String()
+	s.lineNum++
+
+	return true
+}
+
+func (s *recordStartScanner) Text() string {
+	return s.line
+}
+
+func (s *recordStartScanner) Line() int {
+	return s.lineNum
+}
+
+func (s *recordStartScanner) End() string {
+	return s.end
+}
+
+func (s *recordStartScanner) Err() error {
+	return s.lines.Err()
+}
+
+// lineWriter provides buffered output & line+"\n" string concat overhead
+type lineWriter struct {
+	bw *bufio.Writer
+	// src, if set, is what the records written with writeLine stem from;
+	// they then keep its terminators instead of ending in sep.
+	src     lineSource
+	sep     string
+	ending  LineEnding
+	pending bool
+	onMatch func(Match)
+	format  func(Match) string
+}
+
+func newLineWriter(output io.Writer) *lineWriter {
+	return &lineWriter{bw: bufio.NewWriterSize(output, 64*1024), sep: "\n"}
+}
+
+func (w *lineWriter) writeLine(line string) error {
+	if w.src == nil {
+		return w.writeRecord(record{text: line, end: w.sep})
+	}
+
+	r := current(w.src)
+	r.text = line
+
+	return w.writeRecord(r)
+}
+
+// writeRecord writes r followed by its terminator, or by sep when the line
+// endings are converted. An unterminated record only stays so while nothing
+// follows it.
+func (w *lineWriter) writeRecord(r record) error {
+	if w.pending {
+		if _, err := w.bw.WriteString(w.sep); err != nil {
+			return err
+		}
+
+		w.pending = false
+	}
+
+	text, end := r.text, r.end
+
+	if w.ending != LineEndingKeep {
+		if strings.Contains(text, "\n") {
+			text = strings.ReplaceAll(text, "\r\n", "\n")
+			if w.ending == LineEndingCRLF {
+				text = strings.ReplaceAll(text, "\n", "\r\n")
+			}
+		}
+
+		if end != "" {
+			end = w.sep
+		}
+	}
+
+	if _, err := w.bw.WriteString(text); err != nil {
+		return err
+	}
+
+	if end == "" {
+		w.pending = true
+
+		return nil
+	}
+
+	_, err := w.bw.WriteString(end)
+
+	return err
+}
+
+func (w *lineWriter) writeMatch(r record, spans []Span) error {
+	m := Match{Line: r.line, Text: r.text, Spans: spans}
+	w.reportMatch(m)
+
+	if w.format != nil {
+		r.text = w.format(m)
+	}
+
+	return w.writeRecord(r)
+}
+
+func (w *lineWriter) reportMatch(m Match) {
+	if w.onMatch != nil {
+		w.onMatch(m)
+	}
+}
+
+func (w *lineWriter) reportsMatches() bool {
+	return w.onMatch != nil || w.format != nil
+}
+
+func (w *lineWriter) flush() error {
+	return w.bw.Flush()
+}
+
+var errPipeStopped = errors.New("pipeline reader stopped")
+
+// recordPipe connects two commands of a pipeline, passing records in batches
+// along with the number of the input record src was at when they were written.
+type recordPipe struct {
+	src      lineSource
+	ch       chan []record
+	done     chan struct{}
+	stopOnce sync.Once
+	out      []record
+	in       []record
+	current  record
+	drained  bool
+	err      error
+}
+
+func newRecordPipe(src lineSource) *recordPipe {
+	return &recordPipe{
+		src:  src,
+		ch:   make(chan []record, 4),
+		done: make(chan struct{}),
+	}
+}
+
+func (p *recordPipe) writeLine(line string) error {
+	r := current(p.src)
+	r.text = line
+
+	return p.writeRecord(r)
+}
+
+func (p *recordPipe) writeRecord(r record) error {
+	p.out = append(p.out, r)
+	if len(p.out) >= recordBatchSize {
+		return p.send()
+	}
+
+	return nil
+}
+
+func (p *recordPipe) send() error {
+	if len(p.out) == 0 {
+		return nil
+	}
+
+	select {
+	case p.ch <- p.out:
+		p.out = make([]record, 0, recordBatchSize)
+
+		return nil
+	case <-p.done:
+		return errPipeStopped
+	}
+}
+
+func (p *recordPipe) writeMatch(r record, _ []Span) error {
+	return p.writeRecord(r)
+}
+
+func (p *recordPipe) reportMatch(Match) {}
+
+func (p *recordPipe) reportsMatches() bool {
+	return false
+}
+
+func (p *recordPipe) flush() error {
+	return p.send()
+}
+
+func (p *recordPipe) closeWithError(err error) {
+	if err == nil {
+		err = p.send()
+	}
+
+	p.err = err
+	close(p.ch)
+}
+
+// stop unblocks the writer once the reader finishes early.
+func (p *recordPipe) stop() {
+	p.stopOnce.Do(func() { close(p.done) })
+}
+
+func (p *recordPipe) Scan() bool {
+	for len(p.in) == 0 {
+		batch, ok := <-p.ch
+		if !ok {
+			p.drained = true
+
+			return false
+		}
+
+		p.in = batch
+	}
+
+	p.current = p.in[0]
+	p.in = p.in[1:]
+
+	return true
+}
+
+func (p *recordPipe) Text() string {
+	return p.current.text
+}
+
+func (p *recordPipe) Line() int {
+	return p.current.line
+}
+
+func (p *recordPipe) End() string {
+	return p.current.end
+}
+
+func (p *recordPipe) Err() error {
+	if !p.drained || errors.Is(p.err, errPipeStopped) {
+		return nil
+	}
+
+	return p.err
+}
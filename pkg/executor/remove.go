@@ -0,0 +1,107 @@
+package executor
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/Gx2-Studio/ssed/pkg/ast"
+)
+
+func removeBetween(line, start, end string) string {
+	if start == "" || end == "" {
+		return line
+	}
+
+	var b strings.Builder
+
+	for {
+		i := strings.Index(line, start)
+		if i < 0 {
+			break
+		}
+
+		rest := line[i+len(start):]
+
+		j := strings.Index(rest, end)
+		if j < 0 {
+			break
+		}
+
+		b.WriteString(line[:i+len(start)])
+		b.WriteString(end)
+
+		line = rest[j+len(end):]
+	}
+
+	b.WriteString(line)
+
+	return b.String()
+}
+
+func executeRemove(cmd *ast.RemoveCommand, scanner lineSource, lw lineSink) error {
+	var re *regexp.Regexp
+
+	if cmd.IsRegex {
+		var err error
+
+		re, err = regexp.Compile(cmd.Target)
+		if err != nil {
+			return err
+		}
+	}
+
+	find := func(line string) (int, int) {
+		if re != nil {
+			loc := re.FindStringIndex(line)
+			if loc == nil {
+				return -1, -1
+			}
+
+			return loc[0], loc[1]
+		}
+
+		i := strings.Index(line, cmd.Target)
+		if i < 0 || cmd.Target == "" {
+			return -1, -1
+		}
+
+		return i, i + len(cmd.Target)
+	}
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		switch cmd.Type {
+		case ast.RemoveText:
+			if re != nil {
+				line = re.ReplaceAllString(line, "")
+			} else if cmd.Target != "" {
+				line = strings.ReplaceAll(line, cmd.Target, "")
+			}
+		case ast.RemoveAfter:
+			if _, end := find(line); end >= 0 {
+				line = line[:end]
+			}
+		case ast.RemoveFrom:
+			if start, _ := find(line); start >= 0 {
+				line = line[:start]
+			}
+		case ast.RemoveBefore:
+			if start, _ := find(line); start >= 0 {
+				line = line[start:]
+			}
+		case ast.RemoveBetween:
+			line = removeBetween(line, cmd.Target, cmd.End)
+		}
+
+		if err := lw.writeLine(line); err != nil {
+			return err
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	return lw.flush()
+}
@@ -94,7 +94,8 @@ func (lexer *Lexer) skipWhitespace() {
 func (lexer *Lexer) readIdentifier() string {
 	var b strings.Builder
 
-	for unicode.IsLetter(rune(lexer.character)) {
+	for unicode.IsLetter(rune(lexer.character)) ||
+		(lexer.character == '-' && unicode.IsLetter(rune(lexer.peekChar()))) {
 		b.WriteByte(lexer.character)
 		lexer.readChar()
 	}
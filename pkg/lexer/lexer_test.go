@@ -91,6 +91,24 @@ func TestNextToken(t *testing.T) {
 				{Type: EOF, Literal: ""},
 			},
 		},
+		{
+			"hyphenated keyword", "right-align lines in 40", []Token{
+				{Type: RIGHTALIGN, Literal: "right-align"},
+				{Type: LINES, Literal: "lines"},
+				{Type: IN, Literal: "in"},
+				{Type: NUMBER, Literal: "40"},
+				{Type: EOF, Literal: ""},
+			},
+		},
+		{
+			"hyphenated identifier", "replace foo-bar with baz", []Token{
+				{Type: REPLACE, Literal: "replace"},
+				{Type: IDENTIFIER, Literal: "foo-bar"},
+				{Type: WITH, Literal: "with"},
+				{Type: IDENTIFIER, Literal: "baz"},
+				{Type: EOF, Literal: ""},
+			},
+		},
 		{
 			"escaped double quote in string", `"foo \"bar\" baz"`, []Token{
 				{Type: STRING, Literal: `foo "bar" baz`},
@@ -3,37 +3,77 @@ package lexer
 type TokenType string
 
 const (
-	REPLACE    TokenType = "REPLACE"
-	DELETE     TokenType = "DELETE"
-	INSERT     TokenType = "INSERT"
-	SHOW       TokenType = "SHOW"
-	WITH       TokenType = "WITH"
-	FIRST      TokenType = "FIRST"
-	LAST       TokenType = "LAST"
-	BEFORE     TokenType = "BEFORE"
-	AFTER      TokenType = "AFTER"
-	LINE       TokenType = "LINE"
-	LINES      TokenType = "LINES"
-	TO         TokenType = "TO"
-	CONVERT    TokenType = "CONVERT"
-	UPPERCASE  TokenType = "UPPERCASE"
-	LOWERCASE  TokenType = "LOWERCASE"
-	TITLECASE  TokenType = "TITLECASE"
-	TRIM       TokenType = "TRIM"
-	WHITESPACE TokenType = "WHITESPACE"
-	TRAILING   TokenType = "TRAILING"
-	LEADING    TokenType = "LEADING"
-	SPACES     TokenType = "SPACES"
-	REMOVE     TokenType = "REMOVE"
-	COUNT      TokenType = "COUNT"
-	CONTAINING TokenType = "CONTAINING"
-	STARTING   TokenType = "STARTING"
-	ENDING     TokenType = "ENDING"
-	NOT        TokenType = "NOT"
-	WHOLE      TokenType = "WHOLE"
-	WORD       TokenType = "WORD"
-	NUMBERS    TokenType = "NUMBERS"
-	THEN       TokenType = "THEN"
+	REPLACE     TokenType = "REPLACE"
+	DELETE      TokenType = "DELETE"
+	INSERT      TokenType = "INSERT"
+	SHOW        TokenType = "SHOW"
+	WITH        TokenType = "WITH"
+	FIRST       TokenType = "FIRST"
+	LAST        TokenType = "LAST"
+	BEFORE      TokenType = "BEFORE"
+	AFTER       TokenType = "AFTER"
+	LINE        TokenType = "LINE"
+	LINES       TokenType = "LINES"
+	TO          TokenType = "TO"
+	CONVERT     TokenType = "CONVERT"
+	UPPERCASE   TokenType = "UPPERCASE"
+	LOWERCASE   TokenType = "LOWERCASE"
+	TITLECASE   TokenType = "TITLECASE"
+	TRIM        TokenType = "TRIM"
+	WHITESPACE  TokenType = "WHITESPACE"
+	TRAILING    TokenType = "TRAILING"
+	LEADING     TokenType = "LEADING"
+	SPACES      TokenType = "SPACES"
+	REMOVE      TokenType = "REMOVE"
+	COUNT       TokenType = "COUNT"
+	CONTAINING  TokenType = "CONTAINING"
+	STARTING    TokenType = "STARTING"
+	ENDING      TokenType = "ENDING"
+	NOT         TokenType = "NOT"
+	WHOLE       TokenType = "WHOLE"
+	WORD        TokenType = "WORD"
+	NUMBERS     TokenType = "NUMBERS"
+	THEN        TokenType = "THEN"
+	WRAP        TokenType = "WRAP"
+	FILL        TokenType = "FILL"
+	CENTER      TokenType = "CENTER"
+	RIGHTALIGN  TokenType = "RIGHTALIGN"
+	PARAGRAPHS  TokenType = "PARAGRAPHS"
+	COLUMNS     TokenType = "COLUMNS"
+	AT          TokenType = "AT"
+	IN          TokenType = "IN"
+	INDENT      TokenType = "INDENT"
+	DEDENT      TokenType = "DEDENT"
+	BY          TokenType = "BY"
+	TAB         TokenType = "TAB"
+	TABS        TokenType = "TABS"
+	WIDTH       TokenType = "WIDTH"
+	COMMON      TokenType = "COMMON"
+	INDENTATION TokenType = "INDENTATION"
+	ADD         TokenType = "ADD"
+	START       TokenType = "START"
+	END         TokenType = "END"
+	OF          TokenType = "OF"
+	AND         TokenType = "AND"
+	SURROUND    TokenType = "SURROUND"
+	TOGGLE      TokenType = "TOGGLE"
+	COMMENT     TokenType = "COMMENT"
+	ALL         TokenType = "ALL"
+	DIGITS      TokenType = "DIGITS"
+	LETTERS     TokenType = "LETTERS"
+	PUNCTUATION TokenType = "PUNCTUATION"
+	EVERYTHING  TokenType = "EVERYTHING"
+	FROM        TokenType = "FROM"
+	TEXT        TokenType = "TEXT"
+	BETWEEN     TokenType = "BETWEEN"
+	EMPTY       TokenType = "EMPTY"
+	BLANK       TokenType = "BLANK"
+	SQUEEZE     TokenType = "SQUEEZE"
+	FILE        TokenType = "FILE"
+	ENDINGS     TokenType = "ENDINGS"
+	CRLF        TokenType = "CRLF"
+	LF          TokenType = "LF"
+	ENCODING    TokenType = "ENCODING"
 
 	IDENTIFIER TokenType = "IDENTIFIER"
 	STRING     TokenType = "STRING"
@@ -46,37 +86,77 @@ const (
 )
 
 var keywords = map[string]TokenType{
-	"replace":    REPLACE,
-	"delete":     DELETE,
-	"insert":     INSERT,
-	"show":       SHOW,
-	"with":       WITH,
-	"first":      FIRST,
-	"last":       LAST,
-	"before":     BEFORE,
-	"after":      AFTER,
-	"line":       LINE,
-	"lines":      LINES,
-	"to":         TO,
-	"convert":    CONVERT,
-	"uppercase":  UPPERCASE,
-	"lowercase":  LOWERCASE,
-	"titlecase":  TITLECASE,
-	"trim":       TRIM,
-	"whitespace": WHITESPACE,
-	"trailing":   TRAILING,
-	"leading":    LEADING,
-	"spaces":     SPACES,
-	"remove":     REMOVE,
-	"count":      COUNT,
-	"containing": CONTAINING,
-	"starting":   STARTING,
-	"ending":     ENDING,
-	"not":        NOT,
-	"whole":      WHOLE,
-	"word":       WORD,
-	"numbers":    NUMBERS,
-	"then":       THEN,
+	"replace":     REPLACE,
+	"delete":      DELETE,
+	"insert":      INSERT,
+	"show":        SHOW,
+	"with":        WITH,
+	"first":       FIRST,
+	"last":        LAST,
+	"before":      BEFORE,
+	"after":       AFTER,
+	"line":        LINE,
+	"lines":       LINES,
+	"to":          TO,
+	"convert":     CONVERT,
+	"uppercase":   UPPERCASE,
+	"lowercase":   LOWERCASE,
+	"titlecase":   TITLECASE,
+	"trim":        TRIM,
+	"whitespace":  WHITESPACE,
+	"trailing":    TRAILING,
+	"leading":     LEADING,
+	"spaces":      SPACES,
+	"remove":      REMOVE,
+	"count":       COUNT,
+	"containing":  CONTAINING,
+	"starting":    STARTING,
+	"ending":      ENDING,
+	"not":         NOT,
+	"whole":       WHOLE,
+	"word":        WORD,
+	"numbers":     NUMBERS,
+	"then":        THEN,
+	"wrap":        WRAP,
+	"fill":        FILL,
+	"center":      CENTER,
+	"right-align": RIGHTALIGN,
+	"paragraphs":  PARAGRAPHS,
+	"columns":     COLUMNS,
+	"at":          AT,
+	"in":          IN,
+	"indent":      INDENT,
+	"dedent":      DEDENT,
+	"by":          BY,
+	"tab":         TAB,
+	"tabs":        TABS,
+	"width":       WIDTH,
+	"common":      COMMON,
+	"indentation": INDENTATION,
+	"add":         ADD,
+	"start":       START,
+	"end":         END,
+	"of":          OF,
+	"and":         AND,
+	"surround":    SURROUND,
+	"toggle":      TOGGLE,
+	"comment":     COMMENT,
+	"all":         ALL,
+	"digits":      DIGITS,
+	"letters":     LETTERS,
+	"punctuation": PUNCTUATION,
+	"everything":  EVERYTHING,
+	"from":        FROM,
+	"text":        TEXT,
+	"between":     BETWEEN,
+	"empty":       EMPTY,
+	"blank":       BLANK,
+	"squeeze":     SQUEEZE,
+	"file":        FILE,
+	"endings":     ENDINGS,
+	"crlf":        CRLF,
+	"lf":          LF,
+	"encoding":    ENCODING,
 }
 
 type Position struct {
@@ -93,13 +93,21 @@ func (p *Parser) parseSingleCommand() ast.Command {
 		return p.parseTransform()
 	case lexer.COUNT:
 		return p.parseCount()
+	case lexer.WRAP, lexer.FILL, lexer.CENTER, lexer.RIGHTALIGN:
+		return p.parseLayout()
+	case lexer.INDENT, lexer.DEDENT:
+		return p.parseIndent()
+	case lexer.ADD, lexer.SURROUND, lexer.TOGGLE:
+		return p.parseAffix()
+	case lexer.SQUEEZE:
+		return p.parseSqueeze()
 	case lexer.EOF:
 		return p.makeError(
-			"empty input, expected a command (replace, delete, show, insert, convert, count)",
+			"empty input, expected a command (replace, delete, show, insert, convert, count, wrap, fill, center, indent, add, surround)",
 		)
 	default:
 		return p.makeError(
-			"unknown command %q, expected replace, delete, show, insert, convert, or count",
+			"unknown command %q, expected replace, delete, show, insert, convert, count, wrap, fill, center, indent, add, or surround",
 			p.curToken.Literal,
 		)
 	}
@@ -139,6 +147,10 @@ func (p *Parser) parseReplace() ast.Command {
 func (p *Parser) parseDelete() ast.Command {
 	p.nextToken()
 
+	if cmd, ok := p.parseBlankLines(); ok {
+		return cmd
+	}
+
 	if p.curToken.Type == lexer.FIRST || p.curToken.Type == lexer.LAST {
 		isFirst := p.curToken.Type == lexer.FIRST
 
@@ -166,6 +178,15 @@ func (p *Parser) parseDelete() ast.Command {
 		return &ast.DeleteCommand{LastN: n}
 	}
 
+	// An empty pattern would match every line; '' means the empty lines.
+	if p.curToken.Type == lexer.LINES && p.peekToken.Type == lexer.STRING && p.peekToken.Literal == "" {
+		p.nextToken()
+	}
+
+	if p.curToken.Type == lexer.STRING && p.curToken.Literal == "" {
+		return &ast.BlankLinesCommand{Type: ast.BlankDeleteEmpty}
+	}
+
 	if p.curToken.Type == lexer.LINE || p.curToken.Type == lexer.LINES {
 		if p.curToken.Type == lexer.LINES {
 			patternType, target, isRegex, negated, wholeWord, ok := p.parseNaturalPattern()
@@ -191,7 +212,105 @@ func (p *Parser) parseDelete() ast.Command {
 	return &ast.DeleteCommand{Target: target, IsRegex: isRegex}
 }
 
+func (p *Parser) parseBlankLines() (ast.Command, bool) {
+	switch p.curToken.Type {
+	case lexer.EMPTY, lexer.BLANK:
+		if p.peekToken.Type != lexer.LINES {
+			return nil, false
+		}
+
+		cmd := &ast.BlankLinesCommand{Type: ast.BlankDeleteEmpty}
+		if p.curToken.Type == lexer.BLANK {
+			cmd.Type = ast.BlankDeleteBlank
+		}
+
+		p.nextToken()
+
+		return cmd, true
+
+	case lexer.LEADING, lexer.TRAILING:
+		if p.peekToken.Type != lexer.BLANK && p.peekToken.Type != lexer.EMPTY {
+			return nil, false
+		}
+
+		cmd := &ast.BlankLinesCommand{Type: ast.BlankDeleteLeading}
+		if p.curToken.Type == lexer.TRAILING {
+			cmd.Type = ast.BlankDeleteTrailing
+		}
+
+		p.nextToken()
+
+		if p.peekToken.Type == lexer.LINES {
+			p.nextToken()
+		}
+
+		if p.peekToken.Type == lexer.AT {
+			p.nextToken()
+
+			if p.peekToken.Type != lexer.START && p.peekToken.Type != lexer.END {
+				return p.makeError("expected 'start' or 'end' after 'at'"), true
+			}
+
+			p.nextToken()
+
+			if p.peekToken.Type == lexer.OF {
+				p.nextToken()
+			}
+
+			if p.peekToken.Type == lexer.FILE {
+				p.nextToken()
+			}
+		}
+
+		return cmd, true
+	}
+
+	return nil, false
+}
+
+func (p *Parser) parseSqueeze() ast.Command {
+	p.nextToken()
+
+	if p.curToken.Type != lexer.BLANK && p.curToken.Type != lexer.EMPTY {
+		return p.makeError("expected 'blank lines' after 'squeeze'")
+	}
+
+	if p.peekToken.Type == lexer.LINES {
+		p.nextToken()
+	}
+
+	return &ast.BlankLinesCommand{Type: ast.BlankSqueeze}
+}
+
 func (p *Parser) parseShow() ast.Command {
+	cmd := p.parseShowSelection()
+
+	show, ok := cmd.(*ast.ShowCommand)
+	if !ok {
+		return cmd
+	}
+
+	// Any selection can end in "with line numbers".
+	if p.curToken.Type != lexer.WITH && p.peekToken.Type == lexer.WITH {
+		p.nextToken()
+	}
+
+	if p.curToken.Type == lexer.WITH && p.peekToken.Type == lexer.LINE {
+		p.nextToken()
+
+		if p.peekToken.Type != lexer.NUMBERS {
+			return p.makeError("expected 'numbers' after 'with line'")
+		}
+
+		p.nextToken()
+
+		show.ShowLineNumbers = true
+	}
+
+	return show
+}
+
+func (p *Parser) parseShowSelection() ast.Command {
 	p.nextToken()
 
 	if p.curToken.Type == lexer.FIRST || p.curToken.Type == lexer.LAST {
@@ -403,6 +522,19 @@ func (p *Parser) parseTransform() ast.Command {
 	case lexer.CONVERT:
 		p.nextToken()
 
+		if p.curToken.Type == lexer.TABS || p.curToken.Type == lexer.LEADING ||
+			p.curToken.Type == lexer.SPACES {
+			return p.parseConvertIndentation()
+		}
+
+		if p.curToken.Type == lexer.LINE && p.peekToken.Type == lexer.ENDINGS {
+			return p.parseConvertLineEndings()
+		}
+
+		if p.curToken.Type == lexer.ENCODING {
+			return p.parseConvertEncoding()
+		}
+
 		if p.curToken.Type != lexer.TO {
 			return p.makeError("expected 'to' after 'convert'")
 		}
@@ -454,14 +586,438 @@ func (p *Parser) parseTransform() ast.Command {
 			}
 
 			return p.makeError("expected 'spaces' or 'whitespace' after 'remove leading'")
+		case lexer.COMMON:
+			p.nextToken()
+
+			if p.curToken.Type == lexer.INDENTATION || p.curToken.Type == lexer.WHITESPACE {
+				return &ast.TransformCommand{Type: ast.TransformDedentCommon}
+			}
+
+			return p.makeError("expected 'indentation' after 'remove common'")
 		default:
-			return p.makeError("expected 'trailing' or 'leading' after 'remove'")
+			return p.parseRemoveText()
 		}
 	}
 
 	return p.makeError("unexpected token in transform command")
 }
 
+var removeClasses = map[lexer.TokenType]string{
+	lexer.DIGITS:      `\p{Nd}+`,
+	lexer.LETTERS:     `\p{L}+`,
+	lexer.PUNCTUATION: `\p{P}+`,
+	lexer.WHITESPACE:  `\s+`,
+	lexer.SPACES:      ` +`,
+}
+
+func (p *Parser) parseRemoveText() ast.Command {
+	switch p.curToken.Type {
+	case lexer.EOF, lexer.THEN:
+		return p.makeError("expected text to remove, or 'trailing' or 'leading' whitespace after 'remove'")
+
+	case lexer.ALL:
+		p.nextToken()
+
+	case lexer.EVERYTHING:
+		p.nextToken()
+
+		var removeType ast.RemoveType
+
+		switch p.curToken.Type {
+		case lexer.AFTER:
+			removeType = ast.RemoveAfter
+		case lexer.BEFORE:
+			removeType = ast.RemoveBefore
+		case lexer.FROM:
+			removeType = ast.RemoveFrom
+		default:
+			return p.makeError("expected 'after', 'before', or 'from' after 'remove everything'")
+		}
+
+		keyword := p.curToken.Literal
+
+		p.nextToken()
+
+		if p.curToken.Type == lexer.EOF {
+			return p.makeError("expected delimiter after '%s'", keyword)
+		}
+
+		return &ast.RemoveCommand{
+			Type:    removeType,
+			Target:  p.curToken.Literal,
+			IsRegex: p.curToken.Type == lexer.REGEX,
+		}
+
+	case lexer.TEXT, lexer.BETWEEN:
+		if p.curToken.Type == lexer.TEXT {
+			if p.peekToken.Type != lexer.BETWEEN {
+				break
+			}
+
+			p.nextToken()
+		}
+
+		p.nextToken()
+
+		if p.curToken.Type == lexer.EOF {
+			return p.makeError("expected opening delimiter after 'between'")
+		}
+
+		start := p.curToken.Literal
+
+		p.nextToken()
+
+		if p.curToken.Type != lexer.AND {
+			return p.makeError("expected 'and' after %q in remove command", start)
+		}
+
+		p.nextToken()
+
+		if p.curToken.Type == lexer.EOF {
+			return p.makeError("expected closing delimiter after 'and'")
+		}
+
+		return &ast.RemoveCommand{Type: ast.RemoveBetween, Target: start, End: p.curToken.Literal}
+	}
+
+	// "remove digits" means the same as "remove all digits".
+	if class, ok := removeClasses[p.curToken.Type]; ok {
+		return &ast.RemoveCommand{Type: ast.RemoveText, Target: class, IsRegex: true}
+	}
+
+	if p.curToken.Type == lexer.EOF {
+		return p.makeError("expected text to remove after 'all'")
+	}
+
+	return &ast.RemoveCommand{
+		Type:    ast.RemoveText,
+		Target:  p.curToken.Literal,
+		IsRegex: p.curToken.Type == lexer.REGEX,
+	}
+}
+
+func (p *Parser) parseConvertLineEndings() ast.Command {
+	p.nextToken()
+	p.nextToken()
+
+	if p.curToken.Type != lexer.TO {
+		return p.makeError("expected 'to' after 'convert line endings'")
+	}
+
+	p.nextToken()
+
+	switch p.curToken.Type {
+	case lexer.CRLF:
+		return &ast.LineEndingCommand{Style: ast.LineEndingCRLF}
+	case lexer.LF:
+		return &ast.LineEndingCommand{Style: ast.LineEndingLF}
+	default:
+		return p.makeError("expected 'crlf' or 'lf' after 'convert line endings to'")
+	}
+}
+
+func (p *Parser) parseConvertEncoding() ast.Command {
+	p.nextToken()
+
+	if p.curToken.Type != lexer.TO {
+		return p.makeError("expected 'to' after 'convert encoding'")
+	}
+
+	p.nextToken()
+
+	if p.curToken.Type == lexer.EOF || p.curToken.Type == lexer.THEN {
+		return p.makeError("expected an encoding name after 'convert encoding to'")
+	}
+
+	name := p.curToken.Literal
+
+	// Names like utf-8 lex as several tokens with nothing between them.
+	for p.peekToken.Type != lexer.EOF && p.peekToken.Type != lexer.THEN &&
+		p.peekToken.Pos.Line == p.curToken.Pos.Line &&
+		p.peekToken.Pos.Column == p.curToken.Pos.Column+len(p.curToken.Literal) {
+		p.nextToken()
+		name += p.curToken.Literal
+	}
+
+	return &ast.EncodingCommand{Name: name}
+}
+
+func (p *Parser) parseConvertIndentation() ast.Command {
+	cmd := &ast.TransformCommand{Type: ast.TransformTabsToSpaces}
+
+	if p.curToken.Type == lexer.TABS {
+		p.nextToken()
+
+		if p.curToken.Type != lexer.TO {
+			return p.makeError("expected 'to' after 'convert tabs'")
+		}
+
+		p.nextToken()
+
+		if p.curToken.Type == lexer.NUMBER {
+			n, err := strconv.Atoi(p.curToken.Literal)
+			if err != nil || n < 1 {
+				return p.makeError("invalid tab width %q", p.curToken.Literal)
+			}
+
+			cmd.Amount = n
+
+			p.nextToken()
+		}
+
+		if p.curToken.Type != lexer.SPACES {
+			return p.makeError("expected 'spaces' after 'convert tabs to'")
+		}
+
+		return p.parseTabWidth(cmd)
+	}
+
+	cmd.Type = ast.TransformSpacesToTabs
+
+	if p.curToken.Type == lexer.LEADING {
+		p.nextToken()
+	}
+
+	if p.curToken.Type != lexer.SPACES {
+		return p.makeError("expected 'spaces' after 'convert leading'")
+	}
+
+	p.nextToken()
+
+	if p.curToken.Type != lexer.TO {
+		return p.makeError("expected 'to' after 'convert leading spaces'")
+	}
+
+	p.nextToken()
+
+	if p.curToken.Type != lexer.TABS {
+		return p.makeError("expected 'tabs' after 'convert leading spaces to'")
+	}
+
+	return p.parseTabWidth(cmd)
+}
+
+func (p *Parser) parseTabWidth(cmd *ast.TransformCommand) ast.Command {
+	if p.peekToken.Type == lexer.WITH {
+		p.nextToken()
+	}
+
+	if p.peekToken.Type == lexer.TAB {
+		p.nextToken()
+	}
+
+	if p.peekToken.Type != lexer.WIDTH {
+		if p.curToken.Type == lexer.WITH || p.curToken.Type == lexer.TAB {
+			return p.makeError("expected 'width' after %q", p.curToken.Literal)
+		}
+
+		return cmd
+	}
+
+	p.nextToken()
+	p.nextToken()
+
+	if p.curToken.Type != lexer.NUMBER {
+		return p.makeError("expected tab width after 'width', got %q", p.curToken.Literal)
+	}
+
+	n, err := strconv.Atoi(p.curToken.Literal)
+	if err != nil || n < 1 {
+		return p.makeError("invalid tab width %q", p.curToken.Literal)
+	}
+
+	cmd.Amount = n
+
+	return cmd
+}
+
+func (p *Parser) parseIndent() ast.Command {
+	cmd := &ast.TransformCommand{Type: ast.TransformIndent}
+
+	if p.curToken.Type == lexer.DEDENT {
+		cmd.Type = ast.TransformDedent
+	}
+
+	if p.peekToken.Type == lexer.LINE || p.peekToken.Type == lexer.LINES {
+		p.nextToken()
+
+		if p.peekToken.Type == lexer.NUMBER {
+			result := p.parseLineRange(func(lr *ast.LineRange) ast.Command {
+				cmd.LineRange = lr
+
+				return cmd
+			})
+
+			if _, isIllegal := result.(*ast.Illegal); isIllegal {
+				return result
+			}
+		}
+	}
+
+	if p.peekToken.Type != lexer.BY {
+		return cmd
+	}
+
+	p.nextToken()
+	p.nextToken()
+
+	if p.curToken.Type != lexer.NUMBER {
+		return p.makeError("expected amount after 'by', got %q", p.curToken.Literal)
+	}
+
+	n, err := strconv.Atoi(p.curToken.Literal)
+	if err != nil || n < 1 {
+		return p.makeError("invalid indentation amount %q", p.curToken.Literal)
+	}
+
+	cmd.Amount = n
+
+	switch p.peekToken.Type {
+	case lexer.SPACES:
+		p.nextToken()
+	case lexer.TAB, lexer.TABS:
+		p.nextToken()
+
+		cmd.UseTabs = true
+	}
+
+	return cmd
+}
+
+func (p *Parser) parseAffix() ast.Command {
+	switch p.curToken.Type {
+	case lexer.ADD:
+		p.nextToken()
+
+		if p.curToken.Type == lexer.EOF {
+			return p.makeError("expected text to add, got end of input")
+		}
+
+		cmd := &ast.AffixCommand{Text: p.curToken.Literal}
+
+		p.nextToken()
+
+		if p.curToken.Type != lexer.TO {
+			return p.makeError("expected 'to' after %q in add command", cmd.Text)
+		}
+
+		p.nextToken()
+
+		switch p.curToken.Type {
+		case lexer.START:
+			cmd.Position = ast.AffixPrefix
+		case lexer.END:
+			cmd.Position = ast.AffixSuffix
+		default:
+			return p.makeError("expected 'start' or 'end' after 'add ... to', got %q", p.curToken.Literal)
+		}
+
+		if p.peekToken.Type == lexer.OF {
+			p.nextToken()
+		}
+
+		return p.parseAffixAddress(cmd)
+
+	case lexer.SURROUND:
+		p.nextToken()
+
+		if p.curToken.Type == lexer.EOF {
+			return p.makeError("expected pattern to surround, got end of input")
+		}
+
+		cmd := &ast.AffixCommand{
+			Position:     ast.AffixSurround,
+			Match:        p.curToken.Literal,
+			MatchIsRegex: p.curToken.Type == lexer.REGEX,
+		}
+
+		p.nextToken()
+
+		if p.curToken.Type != lexer.WITH {
+			return p.makeError("expected 'with' after %q in surround command", cmd.Match)
+		}
+
+		p.nextToken()
+
+		if p.curToken.Type == lexer.EOF {
+			return p.makeError("expected surrounding text after 'with'")
+		}
+
+		cmd.Text = p.curToken.Literal
+		cmd.ClosingText = cmd.Text
+
+		if p.peekToken.Type == lexer.AND {
+			p.nextToken()
+			p.nextToken()
+
+			cmd.ClosingText = p.curToken.Literal
+		}
+
+		if p.peekToken.Type == lexer.IN {
+			p.nextToken()
+		}
+
+		return p.parseAffixAddress(cmd)
+
+	default:
+		p.nextToken()
+
+		if p.curToken.Type != lexer.COMMENT {
+			return p.makeError("expected 'comment' after 'toggle'")
+		}
+
+		cmd := &ast.AffixCommand{Position: ast.AffixToggleComment, Text: "#"}
+
+		if p.peekToken.Type == lexer.WITH {
+			p.nextToken()
+			p.nextToken()
+
+			if p.curToken.Type == lexer.EOF {
+				return p.makeError("expected comment marker after 'with'")
+			}
+
+			cmd.Text = p.curToken.Literal
+		}
+
+		if p.peekToken.Type == lexer.IN {
+			p.nextToken()
+		}
+
+		return p.parseAffixAddress(cmd)
+	}
+}
+
+func (p *Parser) parseAffixAddress(cmd *ast.AffixCommand) ast.Command {
+	if p.peekToken.Type != lexer.LINE && p.peekToken.Type != lexer.LINES {
+		return cmd
+	}
+
+	p.nextToken()
+
+	if p.curToken.Type == lexer.LINES {
+		patternType, target, isRegex, negated, wholeWord, ok := p.parseNaturalPattern()
+		if ok {
+			cmd.Target = target
+			cmd.IsRegex = isRegex
+			cmd.PatternType = patternType
+			cmd.Negated = negated
+			cmd.WholeWord = wholeWord
+
+			return cmd
+		}
+
+		if p.peekToken.Type != lexer.NUMBER {
+			return cmd
+		}
+	}
+
+	return p.parseLineRange(func(lr *ast.LineRange) ast.Command {
+		cmd.LineRange = lr
+
+		return cmd
+	})
+}
+
 func (p *Parser) parseCount() ast.Command {
 	p.nextToken()
 
@@ -482,3 +1038,51 @@ func (p *Parser) parseCount() ast.Command {
 
 	return &ast.CountCommand{Target: target, IsRegex: isRegex}
 }
+
+func (p *Parser) parseLayout() ast.Command {
+	var layoutType ast.LayoutType
+
+	switch p.curToken.Type {
+	case lexer.WRAP:
+		layoutType = ast.LayoutWrap
+	case lexer.FILL:
+		layoutType = ast.LayoutFill
+	case lexer.CENTER:
+		layoutType = ast.LayoutCenter
+	default:
+		layoutType = ast.LayoutRightAlign
+	}
+
+	if p.peekToken.Type == lexer.LINES || p.peekToken.Type == lexer.PARAGRAPHS {
+		p.nextToken()
+	}
+
+	cmd := &ast.LayoutCommand{Type: layoutType}
+
+	if p.peekToken.Type != lexer.AT && p.peekToken.Type != lexer.IN {
+		return cmd
+	}
+
+	p.nextToken()
+
+	keyword := p.curToken.Literal
+
+	p.nextToken()
+
+	if p.curToken.Type != lexer.NUMBER {
+		return p.makeError("expected column width after '%s', got %q", keyword, p.curToken.Literal)
+	}
+
+	width, err := strconv.Atoi(p.curToken.Literal)
+	if err != nil || width < 1 {
+		return p.makeError("invalid column width %q", p.curToken.Literal)
+	}
+
+	cmd.Width = width
+
+	if p.peekToken.Type == lexer.COLUMNS {
+		p.nextToken()
+	}
+
+	return cmd
+}
@@ -49,6 +49,7 @@ func TestParseDelete(t *testing.T) {
 		target string
 	}{
 		{"simple delete", "delete foo", "foo"},
+		{"delete keyword literal", "delete empty", "empty"},
 		{"delete string", "delete 'error message'", "error message"},
 		{"delete number", "delete 404", "404"},
 	}
@@ -193,6 +194,47 @@ func TestParseShowLineNumbers(t *testing.T) {
 	}
 }
 
+func TestParseShowWithLineNumbers(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		target string
+		firstN int
+	}{
+		{"pattern", "show error with line numbers", "error", 0},
+		{"natural pattern", "show lines containing error with line numbers", "error", 0},
+		{"first lines", "show first 5 lines with line numbers", "", 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lex := lexer.New(tt.input)
+			p := New(lex)
+			cmd := p.Parse()
+
+			showCmd, ok := cmd.(*ast.ShowCommand)
+			if !ok {
+				t.Fatalf("expected ShowCommand, got %T", cmd)
+			}
+
+			if !showCmd.ShowLineNumbers {
+				t.Error("expected ShowLineNumbers to be true")
+			}
+
+			if showCmd.Target != tt.target || showCmd.FirstN != tt.firstN {
+				t.Errorf("expected target %q and first %d, got %q and %d", tt.target, tt.firstN, showCmd.Target, showCmd.FirstN)
+			}
+		})
+	}
+
+	lex := lexer.New("show error with line numbers then convert to uppercase")
+	p := New(lex)
+
+	if _, ok := p.Parse().(*ast.CompoundCommand); !ok {
+		t.Error("expected a pipeline after 'with line numbers'")
+	}
+}
+
 func TestParseShowLine(t *testing.T) {
 	tests := []struct {
 		name  string
@@ -454,6 +496,324 @@ func TestParseTransform(t *testing.T) {
 	}
 }
 
+func TestParseLayout(t *testing.T) {
+	tests := []struct {
+		name       string
+		input      string
+		layoutType ast.LayoutType
+		width      int
+	}{
+		{"wrap lines at columns", "wrap lines at 72 columns", ast.LayoutWrap, 72},
+		{"wrap without width", "wrap lines", ast.LayoutWrap, 0},
+		{"fill paragraphs", "fill paragraphs at 80", ast.LayoutFill, 80},
+		{"fill only", "fill", ast.LayoutFill, 0},
+		{"center lines", "center lines in 60 columns", ast.LayoutCenter, 60},
+		{"right-align lines", "right-align lines", ast.LayoutRightAlign, 0},
+		{"right-align with width", "right-align lines in 40", ast.LayoutRightAlign, 40},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lex := lexer.New(tt.input)
+			p := New(lex)
+			cmd := p.Parse()
+
+			layoutCmd, ok := cmd.(*ast.LayoutCommand)
+			if !ok {
+				t.Fatalf("expected LayoutCommand, got %T", cmd)
+			}
+
+			if layoutCmd.Type != tt.layoutType {
+				t.Errorf("expected type %v, got %v", tt.layoutType, layoutCmd.Type)
+			}
+
+			if layoutCmd.Width != tt.width {
+				t.Errorf("expected width %d, got %d", tt.width, layoutCmd.Width)
+			}
+		})
+	}
+}
+
+func TestParseIndentation(t *testing.T) {
+	tests := []struct {
+		name          string
+		input         string
+		transformType ast.TransformType
+		amount        int
+		useTabs       bool
+		lineRange     *ast.LineRange
+	}{
+		{"indent by spaces", "indent by 2 spaces", ast.TransformIndent, 2, false, nil},
+		{"indent only", "indent", ast.TransformIndent, 0, false, nil},
+		{
+			"indent line range",
+			"indent lines 5 to 12 by 4 spaces",
+			ast.TransformIndent,
+			4,
+			false,
+			&ast.LineRange{Start: 5, End: 12},
+		},
+		{"indent single line by tab", "indent line 3 by 1 tab", ast.TransformIndent, 1, true, &ast.LineRange{Start: 3}},
+		{"dedent by", "dedent by 2", ast.TransformDedent, 2, false, nil},
+		{"dedent lines by", "dedent lines 1 to 4 by 8", ast.TransformDedent, 8, false, &ast.LineRange{Start: 1, End: 4}},
+		{"remove common indentation", "remove common indentation", ast.TransformDedentCommon, 0, false, nil},
+		{"tabs to spaces", "convert tabs to spaces", ast.TransformTabsToSpaces, 0, false, nil},
+		{"tabs to n spaces", "convert tabs to 2 spaces", ast.TransformTabsToSpaces, 2, false, nil},
+		{"tabs to spaces with width", "convert tabs to spaces with tab width 4", ast.TransformTabsToSpaces, 4, false, nil},
+		{"leading spaces to tabs", "convert leading spaces to tabs", ast.TransformSpacesToTabs, 0, false, nil},
+		{"spaces to tabs with width", "convert spaces to tabs width 2", ast.TransformSpacesToTabs, 2, false, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lex := lexer.New(tt.input)
+			p := New(lex)
+			cmd := p.Parse()
+
+			transformCmd, ok := cmd.(*ast.TransformCommand)
+			if !ok {
+				t.Fatalf("expected TransformCommand, got %T", cmd)
+			}
+
+			if transformCmd.Type != tt.transformType {
+				t.Errorf("expected type %v, got %v", tt.transformType, transformCmd.Type)
+			}
+
+			if transformCmd.Amount != tt.amount {
+				t.Errorf("expected amount %d, got %d", tt.amount, transformCmd.Amount)
+			}
+
+			if transformCmd.UseTabs != tt.useTabs {
+				t.Errorf("expected UseTabs %v, got %v", tt.useTabs, transformCmd.UseTabs)
+			}
+
+			if tt.lineRange == nil {
+				if transformCmd.LineRange != nil {
+					t.Errorf("expected no line range, got %+v", transformCmd.LineRange)
+				}
+			} else if transformCmd.LineRange == nil || *transformCmd.LineRange != *tt.lineRange {
+				t.Errorf("expected line range %+v, got %+v", tt.lineRange, transformCmd.LineRange)
+			}
+		})
+	}
+}
+
+func TestParseLineEndings(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		style ast.LineEndingStyle
+	}{
+		{"convert to crlf", "convert line endings to crlf", ast.LineEndingCRLF},
+		{"convert to lf", "convert line endings to lf", ast.LineEndingLF},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lex := lexer.New(tt.input)
+			p := New(lex)
+			cmd := p.Parse()
+
+			endingCmd, ok := cmd.(*ast.LineEndingCommand)
+			if !ok {
+				t.Fatalf("expected LineEndingCommand, got %T", cmd)
+			}
+
+			if endingCmd.Style != tt.style {
+				t.Errorf("expected style %v, got %v", tt.style, endingCmd.Style)
+			}
+		})
+	}
+}
+
+func TestParseEncoding(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		encoding string
+	}{
+		{"utf-8", "convert encoding to utf-8", "utf-8"},
+		{"utf-16le", "convert encoding to utf-16le", "utf-16le"},
+		{"latin1", "convert encoding to latin1", "latin1"},
+		{"quoted", "convert encoding to 'windows-1252'", "windows-1252"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lex := lexer.New(tt.input)
+			p := New(lex)
+			cmd := p.Parse()
+
+			encodingCmd, ok := cmd.(*ast.EncodingCommand)
+			if !ok {
+				t.Fatalf("expected EncodingCommand, got %T", cmd)
+			}
+
+			if encodingCmd.Name != tt.encoding {
+				t.Errorf("expected encoding %q, got %q", tt.encoding, encodingCmd.Name)
+			}
+		})
+	}
+}
+
+func TestParseBlankLines(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     string
+		blankType ast.BlankLinesType
+	}{
+		{"delete empty lines", "delete empty lines", ast.BlankDeleteEmpty},
+		{"delete blank lines", "delete blank lines", ast.BlankDeleteBlank},
+		{"squeeze blank lines", "squeeze blank lines", ast.BlankSqueeze},
+		{"squeeze empty", "squeeze empty", ast.BlankSqueeze},
+		{"delete trailing blank lines", "delete trailing blank lines", ast.BlankDeleteTrailing},
+		{"delete trailing blank lines at end of file", "delete trailing blank lines at end of file", ast.BlankDeleteTrailing},
+		{"delete leading blank lines", "delete leading empty lines at start of file", ast.BlankDeleteLeading},
+		{"delete empty pattern", "delete ''", ast.BlankDeleteEmpty},
+		{"delete lines with empty pattern", `delete lines ""`, ast.BlankDeleteEmpty},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lex := lexer.New(tt.input)
+			p := New(lex)
+			cmd := p.Parse()
+
+			blankCmd, ok := cmd.(*ast.BlankLinesCommand)
+			if !ok {
+				t.Fatalf("expected BlankLinesCommand, got %T", cmd)
+			}
+
+			if blankCmd.Type != tt.blankType {
+				t.Errorf("expected type %v, got %v", tt.blankType, blankCmd.Type)
+			}
+		})
+	}
+}
+
+func TestParseRemove(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected ast.RemoveCommand
+	}{
+		{"remove literal", "remove 'foo'", ast.RemoveCommand{Type: ast.RemoveText, Target: "foo"}},
+		{"remove identifier", "remove invalid", ast.RemoveCommand{Type: ast.RemoveText, Target: "invalid"}},
+		{"remove regex", "remove /[0-9]+/", ast.RemoveCommand{Type: ast.RemoveText, Target: "[0-9]+", IsRegex: true}},
+		{"remove all digits", "remove all digits", ast.RemoveCommand{Type: ast.RemoveText, Target: `\p{Nd}+`, IsRegex: true}},
+		{"remove digits", "remove digits", ast.RemoveCommand{Type: ast.RemoveText, Target: `\p{Nd}+`, IsRegex: true}},
+		{"remove whitespace", "remove whitespace", ast.RemoveCommand{Type: ast.RemoveText, Target: `\s+`, IsRegex: true}},
+		{"remove spaces", "remove spaces", ast.RemoveCommand{Type: ast.RemoveText, Target: ` +`, IsRegex: true}},
+		{"remove quoted class", "remove 'digits'", ast.RemoveCommand{Type: ast.RemoveText, Target: "digits"}},
+		{"remove all literal", "remove all 'x'", ast.RemoveCommand{Type: ast.RemoveText, Target: "x"}},
+		{"remove everything after", "remove everything after '#'", ast.RemoveCommand{Type: ast.RemoveAfter, Target: "#"}},
+		{"remove everything from", "remove everything from '#'", ast.RemoveCommand{Type: ast.RemoveFrom, Target: "#"}},
+		{"remove everything before", "remove everything before /:\\s*/", ast.RemoveCommand{Type: ast.RemoveBefore, Target: `:\s*`, IsRegex: true}},
+		{
+			"remove text between",
+			"remove text between '(' and ')'",
+			ast.RemoveCommand{Type: ast.RemoveBetween, Target: "(", End: ")"},
+		},
+		{"remove between", "remove between '<' and '>'", ast.RemoveCommand{Type: ast.RemoveBetween, Target: "<", End: ">"}},
+		{"remove quoted keyword", "remove 'trailing'", ast.RemoveCommand{Type: ast.RemoveText, Target: "trailing"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lex := lexer.New(tt.input)
+			p := New(lex)
+			cmd := p.Parse()
+
+			removeCmd, ok := cmd.(*ast.RemoveCommand)
+			if !ok {
+				t.Fatalf("expected RemoveCommand, got %T", cmd)
+			}
+
+			if *removeCmd != tt.expected {
+				t.Errorf("expected %+v, got %+v", tt.expected, *removeCmd)
+			}
+		})
+	}
+}
+
+func TestParseAffix(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected ast.AffixCommand
+	}{
+		{
+			"add to start of line range",
+			"add '// ' to start of lines 10 to 20",
+			ast.AffixCommand{Position: ast.AffixPrefix, Text: "// ", LineRange: &ast.LineRange{Start: 10, End: 20}},
+		},
+		{
+			"add to end of lines not ending with",
+			"add ';' to end of lines not ending with ';'",
+			ast.AffixCommand{Position: ast.AffixSuffix, Text: ";", Target: ";", PatternType: ast.PatternEndsWith, Negated: true},
+		},
+		{
+			"add to end of every line",
+			"add ',' to end of lines",
+			ast.AffixCommand{Position: ast.AffixSuffix, Text: ","},
+		},
+		{
+			"surround literal",
+			"surround 'TODO' with '**'",
+			ast.AffixCommand{Position: ast.AffixSurround, Text: "**", ClosingText: "**", Match: "TODO"},
+		},
+		{
+			"surround regex with pair",
+			"surround /[0-9]+/ with '(' and ')' in lines containing total",
+			ast.AffixCommand{
+				Position:     ast.AffixSurround,
+				Text:         "(",
+				ClosingText:  ")",
+				Match:        "[0-9]+",
+				MatchIsRegex: true,
+				Target:       "total",
+			},
+		},
+		{
+			"toggle comment in lines",
+			"toggle comment with '#' in lines 3 to 5",
+			ast.AffixCommand{Position: ast.AffixToggleComment, Text: "#", LineRange: &ast.LineRange{Start: 3, End: 5}},
+		},
+		{
+			"toggle comment default marker",
+			"toggle comment",
+			ast.AffixCommand{Position: ast.AffixToggleComment, Text: "#"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lex := lexer.New(tt.input)
+			p := New(lex)
+			cmd := p.Parse()
+
+			affixCmd, ok := cmd.(*ast.AffixCommand)
+			if !ok {
+				t.Fatalf("expected AffixCommand, got %T", cmd)
+			}
+
+			got := *affixCmd
+			expected := tt.expected
+
+			if (got.LineRange == nil) != (expected.LineRange == nil) ||
+				(got.LineRange != nil && *got.LineRange != *expected.LineRange) {
+				t.Errorf("expected line range %+v, got %+v", expected.LineRange, got.LineRange)
+			}
+
+			got.LineRange, expected.LineRange = nil, nil
+
+			if got != expected {
+				t.Errorf("expected %+v, got %+v", expected, got)
+			}
+		})
+	}
+}
+
 func TestParseCount(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -749,9 +1109,79 @@ func TestParseTransformErrors(t *testing.T) {
 			"expected 'whitespace'",
 		},
 		{
-			"remove invalid",
-			"remove invalid",
-			"expected 'trailing' or 'leading'",
+			"convert line endings invalid target",
+			"convert line endings to mac",
+			"expected 'crlf' or 'lf'",
+		},
+		{
+			"convert encoding missing name",
+			"convert encoding to",
+			"expected an encoding name",
+		},
+		{
+			"squeeze missing blank",
+			"squeeze lines",
+			"expected 'blank lines'",
+		},
+		{
+			"remove missing target",
+			"remove",
+			"expected text to remove, or 'trailing' or 'leading'",
+		},
+		{
+			"remove common missing indentation",
+			"remove common foo",
+			"expected 'indentation'",
+		},
+		{
+			"indent by missing amount",
+			"indent lines 1 to 2 by foo",
+			"expected amount after 'by'",
+		},
+		{
+			"convert tabs missing spaces",
+			"convert tabs to tabs",
+			"expected 'spaces'",
+		},
+		{
+			"remove everything missing position",
+			"remove everything '#'",
+			"expected 'after', 'before', or 'from'",
+		},
+		{
+			"remove between missing and",
+			"remove text between '(' ')'",
+			"expected 'and'",
+		},
+		{
+			"add missing to",
+			"add '#' start of lines",
+			"expected 'to'",
+		},
+		{
+			"add missing start or end",
+			"add '#' to middle",
+			"expected 'start' or 'end'",
+		},
+		{
+			"surround missing with",
+			"surround TODO in '**'",
+			"expected 'with'",
+		},
+		{
+			"toggle missing comment",
+			"toggle '#'",
+			"expected 'comment'",
+		},
+		{
+			"wrap missing width",
+			"wrap lines at wide",
+			"expected column width after 'at'",
+		},
+		{
+			"center zero width",
+			"center lines in 0 columns",
+			"invalid column width",
 		},
 	}
 
@@ -814,6 +1244,18 @@ func TestParseCompound(t *testing.T) {
 			2,
 			[]string{"TRANSFORM", "TRANSFORM"},
 		},
+		{
+			"fill then center",
+			"fill paragraphs at 40 columns then center lines in 60",
+			2,
+			[]string{"LAYOUT", "LAYOUT"},
+		},
+		{
+			"delete blank lines then squeeze",
+			"delete trailing blank lines at end of file then squeeze blank lines",
+			2,
+			[]string{"BLANKLINES", "BLANKLINES"},
+		},
 		{
 			"delete lines then replace",
 			"delete lines starting with '#' then replace TODO with DONE",